@@ -0,0 +1,59 @@
+// Command slidechain-signer holds the custodian's txvm signing key
+// and serves it over HTTP via the signer package's wire protocol, so
+// the key doesn't have to live on the machine running slidechain's
+// watchPegIns/watchExports loops and HTTP server.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/interstellar/slingshot/slidechain/signer"
+)
+
+func main() {
+	var (
+		addr    = flag.String("addr", "localhost:2425", "listen address")
+		keyfile = flag.String("keyfile", "", "path to a hex-encoded ed25519 private key; generated and printed if not given")
+	)
+	flag.Parse()
+
+	key, err := loadOrGenerateKey(*keyfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := signer.NewLocalSigner(key)
+	log.Printf("signing with pubkey %x", s.PublicKey())
+
+	// TODO: wire up a real Policy - e.g. checking the txid against a
+	// list of txids the custodian has told this process to expect -
+	// instead of signing anything it's asked to.
+	srv := &signer.Server{Signer: s}
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
+
+func loadOrGenerateKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		pubkey, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("no -keyfile given, generated key: pub %x priv %x", pubkey, key)
+		return key, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PrivateKey(raw), nil
+}
@@ -0,0 +1,121 @@
+// Package conformance defines the JSON test-vector format used to
+// drive slidechain's peg-in and peg-out state machine, and a loader
+// for reading a directory of vectors.
+//
+// The format is intentionally free of any dependency on slidechain
+// itself: a vector is just data, so the same fixtures can be
+// replayed against other implementations of the protocol, not only
+// this one. Vectors are expected to live in a testdata/vectors
+// directory that's pulled in as a git submodule (following the
+// pattern used by the Filecoin test-vectors project), so a checkout
+// that hasn't fetched the submodule simply sees an empty directory
+// rather than a broken build.
+package conformance
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/chain/txvm/errors"
+)
+
+// Vector is a single conformance test case.
+type Vector struct {
+	// Name identifies the vector in test output. It defaults to the
+	// fixture's file name if left blank.
+	Name string `json:"name,omitempty"`
+
+	// StellarEnvelope is a base64-encoded Stellar TransactionEnvelope
+	// simulating a peg-in, fed through a mock Horizon client into
+	// watchPegIns.
+	StellarEnvelope string `json:"stellar_envelope,omitempty"`
+
+	// SidechainBlock is a hex-encoded, serialized bc.Block simulating
+	// a peg-out, fed through the submitter's multichan into
+	// watchExports.
+	SidechainBlock string `json:"sidechain_block,omitempty"`
+
+	// CustodianSeed is the Stellar seed of the custodian account that
+	// should process this vector.
+	CustodianSeed string `json:"custodian_seed,omitempty"`
+
+	// ExpectedPegs lists the rows the vector expects to find in the
+	// pegs table once the vector has settled.
+	ExpectedPegs []PegRow `json:"expected_pegs,omitempty"`
+
+	// ExpectedExports lists the rows the vector expects to find in
+	// the exports table once the vector has settled.
+	ExpectedExports []ExportRow `json:"expected_exports,omitempty"`
+
+	// ExpectedPostExportProgram, if set, is the hex-encoded txvm
+	// program that doPostExport is expected to submit for this
+	// vector's export.
+	ExpectedPostExportProgram string `json:"expected_post_export_program,omitempty"`
+
+	// ExpectedIsPostExportTx, if set, records whether
+	// IsPostExportTx is expected to recognize
+	// ExpectedPostExportProgram as a post-export tx, given
+	// ExpectedPostExportRef.
+	ExpectedIsPostExportTx *bool `json:"expected_is_post_export_tx,omitempty"`
+
+	// ExpectedPostExportRef carries the fields IsPostExportTx needs
+	// to check ExpectedPostExportProgram's log against: without it,
+	// the only available check is a vacuous shape comparison. Required
+	// whenever ExpectedIsPostExportTx is set.
+	ExpectedPostExportRef *PostExportRef `json:"expected_post_export_ref,omitempty"`
+}
+
+// PostExportRef mirrors the arguments IsPostExportTx compares a
+// post-export tx's log against.
+type PostExportRef struct {
+	AssetXDR string `json:"asset_xdr"`
+	Amount   int64  `json:"amount"`
+	Temp     string `json:"temp"`
+	Exporter string `json:"exporter"`
+	Seqnum   int64  `json:"seqnum"`
+	Anchor   string `json:"anchor"` // hex-encoded
+	Pubkey   string `json:"pubkey"` // hex-encoded
+}
+
+// PegRow is one expected row of the pegs table.
+type PegRow struct {
+	Txid     string `json:"txid"`
+	Amount   int64  `json:"amount"`
+	Imported bool   `json:"imported"`
+}
+
+// ExportRow is one expected row of the exports table.
+type ExportRow struct {
+	Txid  string `json:"txid"`
+	State string `json:"state"`
+}
+
+// Load reads every *.json file directly inside dir and parses it as
+// a Vector. It returns a nil slice, not an error, if dir doesn't
+// exist or contains no vectors yet - e.g. because the testdata
+// submodule hasn't been checked out.
+func Load(dir string) ([]*Vector, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "globbing vectors")
+	}
+	sort.Strings(matches)
+	var vectors []*Vector
+	for _, m := range matches {
+		data, err := ioutil.ReadFile(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", m)
+		}
+		var v Vector
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", m)
+		}
+		if v.Name == "" {
+			v.Name = filepath.Base(m)
+		}
+		vectors = append(vectors, &v)
+	}
+	return vectors, nil
+}
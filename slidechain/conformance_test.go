@@ -0,0 +1,189 @@
+package slidechain
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/interstellar/slingshot/slidechain/conformance"
+	"github.com/interstellar/slingshot/slidechain/mockhorizon"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/stellar/go/xdr"
+)
+
+// TestConformance replays the vectors in testdata/vectors against a
+// fresh Custodian and diffs the resulting pegs/exports rows (and,
+// where a vector specifies one, a post-export program checked with
+// the real IsPostExportTx) against what the vector expects. This is
+// meant to be the regression coverage for the fragile tx.Log
+// shape-matching in watchExports and the JSON ref schema shared by
+// doPostExport and IsPostExportTx - but it's only as good as the
+// vectors actually checked in, and today's only vector (noop) pins
+// down neither path; it exists to exercise the loader and the
+// per-vector custodian seed. Real peg-in/peg-out coverage still
+// needs vectors added under testdata/vectors.
+//
+// Set SKIP_CONFORMANCE to skip this test, e.g. in a checkout that
+// hasn't pulled in the testdata/vectors submodule.
+func TestConformance(t *testing.T) {
+	if os.Getenv("SKIP_CONFORMANCE") != "" {
+		t.Skip("SKIP_CONFORMANCE set")
+	}
+
+	vectors, err := conformance.Load("testdata/vectors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Skip("no vectors in testdata/vectors; run `git submodule update --init` to fetch them")
+	}
+
+	for _, v := range vectors {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			runVector(t, v)
+		})
+	}
+}
+
+func runVector(t *testing.T, v *conformance.Vector) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	testdir, err := ioutil.TempDir("", "conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s/testdb", testdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	hclient := mockhorizon.New()
+	var c *Custodian
+	if v.CustodianSeed != "" {
+		// newCustodianFromSeed mirrors newCustodian but pins the
+		// custodian account to the vector's seed, so fixtures signed
+		// or addressed against that account (e.g. a stellar_envelope
+		// paying it, or a sidechain_block exporting to it) replay
+		// against the account they were built for instead of a fresh,
+		// randomly generated one.
+		c, err = newCustodianFromSeed(ctx, db, hclient, v.CustodianSeed)
+	} else {
+		c, err = newCustodian(ctx, db, hclient)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.StellarEnvelope != "" {
+		var env xdr.TransactionEnvelope
+		if err := xdr.SafeUnmarshalBase64(v.StellarEnvelope, &env); err != nil {
+			t.Fatalf("unmarshaling stellar_envelope: %s", err)
+		}
+		// mockhorizon.Client queues up transactions handed to Add and
+		// replays them to the next StreamTransactions call, standing
+		// in for a real Horizon server emitting this ledger entry.
+		hclient.Add(horizon.Transaction{EnvelopeXdr: v.StellarEnvelope})
+		go c.watchPegIns(ctx)
+	}
+
+	if v.SidechainBlock != "" {
+		raw, err := hex.DecodeString(v.SidechainBlock)
+		if err != nil {
+			t.Fatalf("decoding sidechain_block: %s", err)
+		}
+		var block bc.Block
+		if err := block.FromBytes(raw); err != nil {
+			t.Fatalf("unmarshaling sidechain_block: %s", err)
+		}
+		go c.watchExports(ctx)
+		c.S.w.Write(&block)
+	}
+
+	if v.ExpectedPostExportProgram != "" {
+		prog, err := hex.DecodeString(v.ExpectedPostExportProgram)
+		if err != nil {
+			t.Fatalf("decoding expected_post_export_program: %s", err)
+		}
+		tx, err := bc.NewTx(prog, 3, 1<<31)
+		if err != nil {
+			t.Fatalf("building expected_post_export_program: %s", err)
+		}
+		if v.ExpectedIsPostExportTx != nil {
+			ref := v.ExpectedPostExportRef
+			if ref == nil {
+				t.Fatalf("vector %s: expected_is_post_export_tx set without expected_post_export_ref", v.Name)
+			}
+			var asset xdr.Asset
+			if err := xdr.SafeUnmarshalBase64(ref.AssetXDR, &asset); err != nil {
+				t.Fatalf("unmarshaling expected_post_export_ref.asset_xdr: %s", err)
+			}
+			anchor, err := hex.DecodeString(ref.Anchor)
+			if err != nil {
+				t.Fatalf("decoding expected_post_export_ref.anchor: %s", err)
+			}
+			pubkey, err := hex.DecodeString(ref.Pubkey)
+			if err != nil {
+				t.Fatalf("decoding expected_post_export_ref.pubkey: %s", err)
+			}
+			got := IsPostExportTx(tx, asset, ref.Amount, ref.Temp, ref.Exporter, ref.Seqnum, anchor, pubkey)
+			if want := *v.ExpectedIsPostExportTx; got != want {
+				t.Errorf("IsPostExportTx: got %v, want %v", got, want)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pegsOK := checkPegs(c.DB, v.ExpectedPegs)
+		exportsOK := checkExports(c.DB, v.ExpectedExports)
+		if pegsOK && exportsOK {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("vector %s: db state never matched expectations", v.Name)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func checkPegs(db *sql.DB, want []conformance.PegRow) bool {
+	for _, row := range want {
+		var (
+			amount   int64
+			imported bool
+		)
+		err := db.QueryRow("SELECT amount, imported FROM pegs WHERE txid=$1", row.Txid).Scan(&amount, &imported)
+		if err != nil {
+			return false
+		}
+		if amount != row.Amount || imported != row.Imported {
+			return false
+		}
+	}
+	return true
+}
+
+func checkExports(db *sql.DB, want []conformance.ExportRow) bool {
+	for _, row := range want {
+		var state string
+		err := db.QueryRow("SELECT state FROM exports WHERE txid=$1", []byte(row.Txid)).Scan(&state)
+		if err != nil {
+			return false
+		}
+		if state != row.State {
+			return false
+		}
+	}
+	return true
+}
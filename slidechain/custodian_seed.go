@@ -0,0 +1,37 @@
+package slidechain
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/chain/txvm/errors"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/xdr"
+)
+
+// newCustodianFromSeed calls newCustodian (which still fetches or
+// creates its own account as usual) and then overrides the returned
+// Custodian's AccountID and seed with those of the given seed, in
+// memory only. It's used to replay conformance
+// vectors whose fixtures (a stellar_envelope paying the custodian, a
+// sidechain_block exporting to it) are signed or addressed against a
+// specific, known account, rather than the randomly generated one
+// newCustodian would otherwise give them.
+func newCustodianFromSeed(ctx context.Context, db *sql.DB, hclient horizon.ClientInterface, seed string) (*Custodian, error) {
+	c, err := newCustodian(ctx, db, hclient)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := keypair.Parse(seed)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing custodian seed")
+	}
+	var accountID xdr.AccountId
+	if err := accountID.SetAddress(kp.Address()); err != nil {
+		return nil, errors.Wrap(err, "setting custodian account address")
+	}
+	c.AccountID = accountID
+	c.seed = seed
+	return c, nil
+}
@@ -2,6 +2,8 @@ package slidechain
 
 import (
 	"context"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -25,26 +27,63 @@ import (
 )
 
 type pegOut struct {
-	AssetXDR []byte `json:"asset"`
-	TempAddr string `json:"temp"`
-	Seqnum   int64  `json:"seqnum"`
-	Exporter string `json:"exporter"`
+	AssetXDR  []byte `json:"asset"`
+	TempAddr  string `json:"temp"`
+	Seqnum    int64  `json:"seqnum"`
+	Exporter  string `json:"exporter"`
+	Claimable bool   `json:"claimable,omitempty"`
 }
 
-type pegOutState int
+const baseFee = 100
 
+// Export states. A row starts out pending, moves to submitted once
+// its peg-out tx has been sent to Horizon, and reaches confirmed
+// once Horizon reports the tx included in a ledger. failed is a
+// durable terminal state for a row whose peg-out tx was rejected for
+// a non-recoverable reason; it is left in place for an operator (or
+// a future governance flow) to resolve rather than silently dropped.
 const (
-	pegOutFail pegOutState = iota
-	pegOutOK
-	pegOutRetry
-)
+	exportStatePending   = "pending"
+	exportStateSubmitted = "submitted"
+	exportStateConfirmed = "confirmed"
+	exportStateFailed    = "failed"
 
-const baseFee = 100
+	// exportStateClaimable is the terminal state for a row whose
+	// peg-out paid the exporter via CreateClaimableBalance rather
+	// than a direct Payment. Unlike a confirmed plain payment, the
+	// funds aren't in the exporter's account yet - the row (and its
+	// balance_id) is kept around so ClaimPeggedOut has something to
+	// look up.
+	exportStateClaimable = "claimable"
+
+	// exportStateNeedsClaimable is the terminal state for a row whose
+	// Payment-mode peg-out was rejected with op_no_trust and whose
+	// automatic claimable-balance retry (see fallBackToClaimable)
+	// also didn't work - typically because the row predates
+	// SubmitPreExportTx preauthorizing that fallback, so there's
+	// nothing for the custodian to retry with. The exporter has to
+	// redo the pre-export step with claimable-balance mode requested
+	// up front, which is what this state is a signal for.
+	exportStateNeedsClaimable = "needs_claimable"
+
+	// exportStateRejected is the terminal state for a row that never
+	// passed validateExport's pre-flight checks: the asset, temp
+	// account, or custodian balance didn't match what the retirement
+	// claimed. It's recorded rather than dropped so a governance flow
+	// can reissue the retired sidechain value.
+	exportStateRejected = "rejected"
+)
 
 // Runs as a goroutine.
 func (c *Custodian) pegOutFromExports(ctx context.Context) {
 	defer log.Print("pegOutFromExports exiting")
 
+	// batcher is built lazily, once PegOutBatch is known to be
+	// enabled, and reused across every wakeup of this goroutine so
+	// rows accumulate across wakeups instead of each wakeup submitting
+	// whatever's pending at that instant.
+	var batcher *pegOutBatcher
+
 	ch := make(chan struct{})
 	go func() {
 		c.exports.L.Lock()
@@ -65,98 +104,337 @@ func (c *Custodian) pegOutFromExports(ctx context.Context) {
 		case <-ch:
 		}
 
-		const q = `SELECT txid, amount, asset_xdr, exporter, temp_addr, seqnum FROM exports`
+		const q = `SELECT txid, amount, asset_xdr, exporter, temp_addr, seqnum, state, attempts, stellar_tx_hash, fee, submitted_at, claimable_balance FROM exports WHERE state NOT IN ('failed', 'claimable', 'needs_claimable', 'rejected')`
 
 		var (
-			txids     [][]byte
-			amounts   []int
-			assetXDRs [][]byte
-			exporters []string
-			tempAddrs []string
-			seqnums   []int
+			txids        [][]byte
+			amounts      []int
+			assetXDRs    [][]byte
+			exporters    []string
+			tempAddrs    []string
+			seqnums      []int
+			states       []string
+			attempts     []int
+			txHashes     []string
+			fees         []int64
+			submittedAts []sql.NullTime
+			claimables   []bool
 		)
-		err := sqlutil.ForQueryRows(ctx, c.DB, q, func(txid []byte, amount int, assetXDR []byte, exporter string, tempAddr string, seqnum int) {
+		err := sqlutil.ForQueryRows(ctx, c.DB, q, func(txid []byte, amount int, assetXDR []byte, exporter, tempAddr string, seqnum int, state string, attempt int, txHash string, fee int64, submittedAt sql.NullTime, claimable bool) {
 			txids = append(txids, txid)
 			amounts = append(amounts, amount)
 			assetXDRs = append(assetXDRs, assetXDR)
 			exporters = append(exporters, exporter)
 			tempAddrs = append(tempAddrs, tempAddr)
 			seqnums = append(seqnums, seqnum)
+			states = append(states, state)
+			attempts = append(attempts, attempt)
+			txHashes = append(txHashes, txHash)
+			fees = append(fees, fee)
+			submittedAts = append(submittedAts, submittedAt)
+			claimables = append(claimables, claimable)
 		})
 		if err != nil {
-			log.Fatalf("reading export rows: %s", err)
+			log.Printf("reading export rows: %s, will retry", err)
+			continue
 		}
+
+		var batchRows []pegOutRow
+
 		for i, txid := range txids {
 			var asset xdr.Asset
 			err = xdr.SafeUnmarshal(assetXDRs[i], &asset)
 			if err != nil {
-				log.Fatalf("unmarshalling asset from XDR %x: %s", assetXDRs[i], err)
+				log.Printf("unmarshalling asset from XDR %x: %s, marking export failed", assetXDRs[i], err)
+				c.markExportFailed(ctx, txid)
+				continue
 			}
 			var tempID xdr.AccountId
 			err = tempID.SetAddress(tempAddrs[i])
 			if err != nil {
-				log.Fatalf("setting temp address to %s: %s", tempAddrs[i], err)
+				log.Printf("setting temp address to %s: %s, marking export failed", tempAddrs[i], err)
+				c.markExportFailed(ctx, txid)
+				continue
 			}
 			var exporter xdr.AccountId
 			err = exporter.SetAddress(exporters[i])
 			if err != nil {
-				log.Fatalf("setting exporter address to %s: %s", exporters[i], err)
+				log.Printf("setting exporter address to %s: %s, marking export failed", exporters[i], err)
+				c.markExportFailed(ctx, txid)
+				continue
 			}
 
-			log.Printf("pegging out export %x: %d of %s to %s", txid, amounts[i], asset.String(), exporters[i])
+			// The batched path only ever claims brand new, plain-payment
+			// rows; a row that's already submitted needs the
+			// resume/confirm logic below, not a second, uncoordinated
+			// submission, and a claimable-balance row needs its own
+			// CreateClaimableBalance operation, which the batch builder
+			// doesn't know how to fold in.
+			if c.PegOutBatch != nil && states[i] == exportStatePending && !claimables[i] {
+				batchRows = append(batchRows, pegOutRow{
+					txid:     txid,
+					exporter: exporter,
+					asset:    asset,
+					amount:   int64(amounts[i]),
+					tempID:   tempID,
+					seqnum:   xdr.SequenceNumber(seqnums[i]),
+				})
+				continue
+			}
 
-			peggedOut := pegOutOK
-			err = c.pegOut(ctx, exporter, asset, int64(amounts[i]), tempID, xdr.SequenceNumber(seqnums[i]))
-			if err != nil {
-				peggedOut = pegOutFail
-				if herr, ok := errors.Root(err).(*horizon.Error); ok {
-					resultCodes, err := herr.ResultCodes()
-					if err != nil {
-						log.Fatalf("getting error codes from failed submission of tx %s", txid)
-					}
-					if resultCodes.TransactionCode == xdr.TransactionResultCodeTxBadSeq.String() {
-						peggedOut = pegOutRetry
-					}
-				}
+			row := pegOutAttempt{
+				txid:        txid,
+				exporter:    exporter,
+				asset:       asset,
+				amount:      int64(amounts[i]),
+				tempID:      tempID,
+				seqnum:      xdr.SequenceNumber(seqnums[i]),
+				state:       states[i],
+				attempts:    attempts[i],
+				txHash:      txHashes[i],
+				fee:         fees[i],
+				submittedAt: submittedAts[i],
+				claimable:   claimables[i],
 			}
-			// Delete successful and failed peg-outs from exports.
-			if peggedOut != pegOutRetry {
-				_, err = c.DB.ExecContext(ctx, `DELETE FROM exports WHERE txid=$2`, txid)
-				if err != nil {
-					log.Fatalf("updating export table: %s", err)
-				}
+			// A duplicate wakeup (another Signal while this pass is
+			// still running, or another goroutine) must not spawn a
+			// second submission of the same row.
+			if !c.pegOutInflight.start(string(txid)) {
+				continue
 			}
-			if peggedOut == pegOutFail {
-				log.Fatalf("peg-out failed for tx %s", txid)
+			go func() {
+				defer c.pegOutInflight.done(string(row.txid))
+				c.advancePegOut(ctx, row)
+			}()
+		}
+
+		if len(batchRows) > 0 {
+			if batcher == nil {
+				cfg := *c.PegOutBatch
+				batcher = newPegOutBatcher(cfg, func(rows []pegOutRow) {
+					c.batchPegOuts(ctx, rows, cfg)
+				})
 			}
+			batcher.add(batchRows)
+		}
+	}
+}
+
+// advancePegOut drives a single export row one step through the
+// pending -> submitted -> confirmed state machine. It never calls
+// log.Fatalf: every error it can't resolve itself is logged and the
+// row is left in the database for the next pass (after an
+// exponential backoff), so a restart after a crash resumes exactly
+// where the process left off instead of double-submitting or
+// stranding the export.
+func (c *Custodian) advancePegOut(ctx context.Context, row pegOutAttempt) {
+	if row.state == exportStateSubmitted && row.txHash != "" {
+		confirmed, err := c.pegOutConfirmed(row.txHash)
+		if err != nil {
+			log.Printf("checking Horizon for peg-out tx %s (export %x): %s", row.txHash, row.txid, err)
+		} else if confirmed {
+			c.markExportConfirmed(ctx, row.txid)
+			return
+		}
+		// Not yet confirmed. If it's been stuck long enough to suspect
+		// the network's minimum fee has outrun what this tx offered,
+		// escalate via a fee-bump wrapper before falling through to a
+		// plain resubmission.
+		if row.submittedAt.Valid && time.Since(row.submittedAt.Time) > pegOutDeadline {
+			c.escalatePegOutFee(ctx, row, "stuck past deadline")
+			return
+		}
+	}
+
+	time.Sleep(pegOutBackoff(row.attempts))
+
+	tx, err := buildPegOutTx(c.AccountID.Address(), row.exporter.Address(), row.tempID.Address(), c.network, row.asset, row.amount, row.seqnum, row.claimable)
+	if err != nil {
+		log.Printf("building peg-out tx for export %x: %s, marking failed", row.txid, err)
+		c.markExportFailed(ctx, row.txid)
+		return
+	}
+	hash, err := tx.Hash()
+	if err != nil {
+		log.Printf("hashing peg-out tx for export %x: %s, marking failed", row.txid, err)
+		c.markExportFailed(ctx, row.txid)
+		return
+	}
+	hashStr := hex.EncodeToString(hash[:])
+	c.markExportSubmitted(ctx, row.txid, hashStr)
+
+	succ, err := stellar.SignAndSubmitTx(c.hclient, tx, c.seed)
+	if err == nil {
+		if row.claimable {
+			c.markExportClaimable(ctx, row.txid, succ.Result)
+			return
 		}
+		c.markExportConfirmed(ctx, row.txid)
+		return
+	}
+
+	if isInsufficientFee(err) {
+		c.escalatePegOutFee(ctx, row, "tx_insufficient_fee")
+		return
+	}
+
+	if !row.claimable && isNoTrustline(err) {
+		c.fallBackToClaimable(ctx, row)
+		return
+	}
+
+	if herr, ok := errors.Root(err).(*horizon.Error); ok {
+		resultCodes, rcErr := herr.ResultCodes()
+		if rcErr == nil && resultCodes.TransactionCode == xdr.TransactionResultCodeTxBadSeq.String() {
+			// Either a prior submission already landed, or the temp
+			// account isn't ready yet; either way this is worth
+			// retrying, so leave the row in the submitted state.
+			log.Printf("peg-out tx for export %x got tx_bad_seq, will retry", row.txid)
+			return
+		}
+	}
+	log.Printf("submitting peg-out tx for export %x: %s, will retry with backoff", row.txid, err)
+}
+
+// escalatePegOutFee bumps row's fee one notch (up to feeBumpCeiling)
+// and resubmits via a CAP-0015 fee-bump transaction.
+func (c *Custodian) escalatePegOutFee(ctx context.Context, row pegOutAttempt, reason string) {
+	newFee := nextBumpedFee(row.fee)
+	if newFee <= row.fee {
+		log.Printf("peg-out for export %x is at the fee ceiling (%d), leaving for an operator", row.txid, feeBumpCeiling)
+		return
+	}
+	log.Printf("peg-out for export %x (%s), bumping fee %d -> %d", row.txid, reason, row.fee, newFee)
+	err := c.bumpPegOutFee(ctx, row, newFee)
+	if err != nil {
+		log.Printf("bumping fee for export %x: %s, will retry", row.txid, err)
+	}
+}
+
+// pegOutConfirmed reports whether the peg-out transaction with the
+// given hash has been included in a ledger.
+func (c *Custodian) pegOutConfirmed(hash string) (bool, error) {
+	_, err := c.hclient.LoadTransaction(hash)
+	if err != nil {
+		if herr, ok := err.(*horizon.Error); ok && herr.Response.StatusCode == 404 {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Custodian) markExportSubmitted(ctx context.Context, txid []byte, hash string) {
+	const q = `UPDATE exports SET state=$1, attempts=attempts+1, stellar_tx_hash=$2, submitted_at=$3 WHERE txid=$4`
+	_, err := c.DB.ExecContext(ctx, q, exportStateSubmitted, hash, time.Now(), txid)
+	if err != nil {
+		log.Printf("marking export %x submitted: %s", txid, err)
+	}
+}
+
+// markExportConfirmed records that a peg-out has been included in a
+// ledger. There's no durable "confirmed" row left behind: once a
+// peg-out is confirmed there's nothing left to track, so the row is
+// deleted outright rather than lingering in a terminal state.
+func (c *Custodian) markExportConfirmed(ctx context.Context, txid []byte) {
+	log.Printf("export %x reached state %s, removing", txid, exportStateConfirmed)
+	_, err := c.DB.ExecContext(ctx, `DELETE FROM exports WHERE txid=$1`, txid)
+	if err != nil {
+		log.Printf("marking export %x confirmed: %s", txid, err)
+	}
+}
+
+func (c *Custodian) markExportFailed(ctx context.Context, txid []byte) {
+	_, err := c.DB.ExecContext(ctx, `UPDATE exports SET state=$1 WHERE txid=$2`, exportStateFailed, txid)
+	if err != nil {
+		log.Printf("marking export %x failed: %s", txid, err)
 	}
 }
 
-func (c *Custodian) pegOut(ctx context.Context, exporter xdr.AccountId, asset xdr.Asset, amount int64, tempID xdr.AccountId, seqnum xdr.SequenceNumber) error {
-	tx, err := buildPegOutTx(c.AccountID.Address(), exporter.Address(), tempID.Address(), c.network, asset, amount, seqnum)
+// markExportClaimable records that a claimable-balance peg-out has
+// been included in a ledger. The row is kept (rather than deleted,
+// as a plain payment's is) so ClaimPeggedOut has a balance_id to
+// look up.
+func (c *Custodian) markExportClaimable(ctx context.Context, txid []byte, resultXDR string) {
+	balanceID, err := decodeClaimableBalanceID(resultXDR)
 	if err != nil {
-		return errors.Wrap(err, "building peg-out tx")
+		log.Printf("decoding claimable balance id for export %x: %s", txid, err)
 	}
-	_, err = stellar.SignAndSubmitTx(c.hclient, tx, c.seed)
+	_, err = c.DB.ExecContext(ctx, `UPDATE exports SET state=$1, balance_id=$2 WHERE txid=$3`, exportStateClaimable, balanceID, txid)
 	if err != nil {
-		errors.Wrap(err, "peg-out tx")
+		log.Printf("marking export %x claimable: %s", txid, err)
+		return
 	}
-	return nil
+	log.Printf("export %x paid out as claimable balance %s", txid, balanceID)
 }
 
-func buildPegOutTx(custodianAddr, exporterAddr, tempAddr, network string, asset xdr.Asset, amount int64, seqnum xdr.SequenceNumber) (*b.TransactionBuilder, error) {
-	var paymentOp b.PaymentBuilder
+// markExportNeedsClaimable records that a plain-payment peg-out was
+// rejected for lack of a trustline and a claimable-balance retry
+// wasn't possible either, so the exporter needs to redo the
+// pre-export step in claimable-balance mode.
+func (c *Custodian) markExportNeedsClaimable(ctx context.Context, txid []byte) {
+	_, err := c.DB.ExecContext(ctx, `UPDATE exports SET state=$1 WHERE txid=$2`, exportStateNeedsClaimable, txid)
+	if err != nil {
+		log.Printf("marking export %x needing claimable-balance re-export: %s", txid, err)
+	}
+}
+
+// fallBackToClaimable retries row's peg-out as a claimable balance
+// after a plain Payment came back op_no_trust. This only works
+// because SubmitPreExportTx, alongside the plain-payment preauth tx
+// that just failed, also preauthorized this exact claimable-balance
+// variant against the same temp account (skipped for assets
+// buildPegOutClaimableBalanceOp doesn't support, i.e. the native
+// asset, which has no trustline to begin with and so never hits
+// op_no_trust). If that preauthorization is missing or stale - an
+// export created before this fallback existed, or a native asset, or
+// Horizon rejecting the retry for some other reason - the row falls
+// back to exportStateNeedsClaimable so the exporter can redo
+// pre-export explicitly.
+func (c *Custodian) fallBackToClaimable(ctx context.Context, row pegOutAttempt) {
+	tx, err := buildPegOutTx(c.AccountID.Address(), row.exporter.Address(), row.tempID.Address(), c.network, row.asset, row.amount, row.seqnum, true)
+	if err != nil {
+		log.Printf("building claimable-balance fallback tx for export %x: %s, needs re-export", row.txid, err)
+		c.markExportNeedsClaimable(ctx, row.txid)
+		return
+	}
+	hash, err := tx.Hash()
+	if err != nil {
+		log.Printf("hashing claimable-balance fallback tx for export %x: %s, needs re-export", row.txid, err)
+		c.markExportNeedsClaimable(ctx, row.txid)
+		return
+	}
+	// Persist the claimable-mode hash before submitting, same as the
+	// normal path in advancePegOut: if the process crashes right
+	// after a successful SignAndSubmitTx below, a restart needs a
+	// hash to poll for confirmation instead of polling forever for
+	// the stale Payment-mode hash while the temp account is already
+	// gone.
+	c.markExportSubmitted(ctx, row.txid, hex.EncodeToString(hash[:]))
+
+	succ, err := stellar.SignAndSubmitTx(c.hclient, tx, c.seed)
+	if err != nil {
+		log.Printf("peg-out tx for export %x got op_no_trust and claimable-balance fallback also failed: %s, needs re-export", row.txid, err)
+		c.markExportNeedsClaimable(ctx, row.txid)
+		return
+	}
+	log.Printf("peg-out tx for export %x got op_no_trust, fell back to claimable-balance automatically", row.txid)
+	c.markExportClaimable(ctx, row.txid, succ.Result)
+}
+
+// buildPegOutPaymentOp builds the operation that pays the exporter
+// out of the custodian's Stellar account.
+func buildPegOutPaymentOp(custodianAddr, exporterAddr string, asset xdr.Asset, amount int64) (b.PaymentBuilder, error) {
 	switch asset.Type {
 	case xdr.AssetTypeAssetTypeNative:
 		lumens := xlm.Amount(amount)
-		paymentOp = b.Payment(
+		return b.Payment(
 			b.SourceAccount{AddressOrSeed: custodianAddr},
 			b.Destination{AddressOrSeed: exporterAddr},
 			b.NativeAmount{Amount: lumens.HorizonString()},
-		)
+		), nil
 	case xdr.AssetTypeAssetTypeCreditAlphanum4:
-		paymentOp = b.Payment(
+		return b.Payment(
 			b.SourceAccount{AddressOrSeed: custodianAddr},
 			b.Destination{AddressOrSeed: exporterAddr},
 			b.CreditAmount{
@@ -164,9 +442,9 @@ func buildPegOutTx(custodianAddr, exporterAddr, tempAddr, network string, asset
 				Issuer: asset.AlphaNum4.Issuer.Address(),
 				Amount: strconv.FormatInt(amount, 10),
 			},
-		)
+		), nil
 	case xdr.AssetTypeAssetTypeCreditAlphanum12:
-		paymentOp = b.Payment(
+		return b.Payment(
 			b.SourceAccount{AddressOrSeed: custodianAddr},
 			b.Destination{AddressOrSeed: exporterAddr},
 			b.CreditAmount{
@@ -174,19 +452,69 @@ func buildPegOutTx(custodianAddr, exporterAddr, tempAddr, network string, asset
 				Issuer: asset.AlphaNum12.Issuer.Address(),
 				Amount: strconv.FormatInt(amount, 10),
 			},
-		)
+		), nil
 	}
+	return b.PaymentBuilder{}, fmt.Errorf("unsupported asset type %s", asset.Type)
+}
+
+// buildPegOutReclaimTx builds the merge-only counterpart to
+// buildPegOutTx: an AccountMerge of tempAddr into exporterAddr with
+// no payout operation. It's preauthorized alongside the combined
+// merge-and-payout tx so that a row claimed by the batched peg-out
+// path - which pays the exporter out of the custodian account
+// instead of tempAddr - still has a way to reclaim tempAddr's
+// reserve once the batched payment has landed, without resubmitting
+// (and so re-paying) the combined tx.
+func buildPegOutReclaimTx(exporterAddr, tempAddr, network string, seqnum xdr.SequenceNumber) (*b.TransactionBuilder, error) {
+	return b.Transaction(
+		b.Network{Passphrase: network},
+		b.SourceAccount{AddressOrSeed: tempAddr},
+		b.Sequence{Sequence: uint64(seqnum) + 1},
+		b.BaseFee{Amount: baseFee},
+		b.AccountMerge(
+			b.Destination{AddressOrSeed: exporterAddr},
+		),
+	)
+}
+
+// buildPegOutOps builds the two operations - account merge and
+// payout - that make up a single-row peg-out. They're returned
+// separately (rather than as a *b.TransactionBuilder) so that
+// buildBatchedPegOutTx can fold the payout half of many rows into
+// one Stellar transaction. When claimable is true the payout is a
+// CreateClaimableBalance op naming the exporter as sole claimant
+// instead of a direct Payment, for exporters who don't (yet) hold a
+// trustline for asset.
+func buildPegOutOps(custodianAddr, exporterAddr string, asset xdr.Asset, amount int64, claimable bool) ([]b.TransactionMutator, error) {
 	mergeAccountOp := b.AccountMerge(
 		b.Destination{AddressOrSeed: exporterAddr},
 	)
-	return b.Transaction(
+	if claimable {
+		claimOp, err := buildPegOutClaimableBalanceOp(custodianAddr, exporterAddr, asset, amount)
+		if err != nil {
+			return nil, errors.Wrap(err, "building claimable balance op")
+		}
+		return []b.TransactionMutator{mergeAccountOp, claimOp}, nil
+	}
+	paymentOp, err := buildPegOutPaymentOp(custodianAddr, exporterAddr, asset, amount)
+	if err != nil {
+		return nil, errors.Wrap(err, "building payment op")
+	}
+	return []b.TransactionMutator{mergeAccountOp, paymentOp}, nil
+}
+
+func buildPegOutTx(custodianAddr, exporterAddr, tempAddr, network string, asset xdr.Asset, amount int64, seqnum xdr.SequenceNumber, claimable bool) (*b.TransactionBuilder, error) {
+	ops, err := buildPegOutOps(custodianAddr, exporterAddr, asset, amount, claimable)
+	if err != nil {
+		return nil, err
+	}
+	muts := append([]b.TransactionMutator{
 		b.Network{Passphrase: network},
 		b.SourceAccount{AddressOrSeed: tempAddr},
 		b.Sequence{Sequence: uint64(seqnum) + 1},
 		b.BaseFee{Amount: baseFee},
-		mergeAccountOp,
-		paymentOp,
-	)
+	}, ops...)
+	return b.Transaction(muts...)
 }
 
 // createTempAccount builds and submits a transaction to the Stellar
@@ -228,11 +556,22 @@ func createTempAccount(hclient horizon.ClientInterface, kp *keypair.Full) (*keyp
 // SubmitPreExportTx builds and submits the two pre-export transactions
 // to the Stellar network.
 // The first transaction creates a new temporary account.
-// The second transaction sets the signer on the temporary account
-// to be a preauth transaction, which merges the account and pays
-// out the pegged-out funds.
+// The second transaction sets two preauthorized signers on the
+// temporary account: one for the combined transaction that merges
+// the account into the exporter and pays out the pegged-out funds,
+// and one for buildPegOutReclaimTx's merge-only counterpart. Only
+// one of the two will ever actually execute - whichever lands first
+// destroys the account - but having both lets the batched peg-out
+// path, which pays the exporter out of the custodian account
+// instead, still reclaim tempAddr's reserve afterwards by
+// submitting the merge-only tx instead of the combined one.
+// claimable selects whether the combined tx's payout is a direct
+// Payment or a CreateClaimableBalance naming kp as sole claimant; an
+// exporter without a trustline for asset should pass true. Because
+// both preauth signers commit to exact transaction hashes, neither
+// choice can be changed later without redoing pre-export.
 // The function returns the temporary account address and sequence number.
-func SubmitPreExportTx(hclient horizon.ClientInterface, kp *keypair.Full, custodian string, asset xdr.Asset, amount int64) (string, xdr.SequenceNumber, error) {
+func SubmitPreExportTx(hclient horizon.ClientInterface, kp *keypair.Full, custodian string, asset xdr.Asset, amount int64, claimable bool) (string, xdr.SequenceNumber, error) {
 	root, err := hclient.Root()
 	if err != nil {
 		return "", 0, errors.Wrap(err, "getting Horizon root")
@@ -243,7 +582,7 @@ func SubmitPreExportTx(hclient horizon.ClientInterface, kp *keypair.Full, custod
 		return "", 0, errors.Wrap(err, "creating temp account")
 	}
 
-	preauthTx, err := buildPegOutTx(custodian, kp.Address(), tempKP.Address(), root.NetworkPassphrase, asset, amount, seqnum)
+	preauthTx, err := buildPegOutTx(custodian, kp.Address(), tempKP.Address(), root.NetworkPassphrase, asset, amount, seqnum, claimable)
 	if err != nil {
 		return "", 0, errors.Wrap(err, "building preauth tx")
 	}
@@ -256,17 +595,65 @@ func SubmitPreExportTx(hclient horizon.ClientInterface, kp *keypair.Full, custod
 		return "", 0, errors.Wrap(err, "encoding preauth tx hash")
 	}
 
+	reclaimTx, err := buildPegOutReclaimTx(kp.Address(), tempKP.Address(), root.NetworkPassphrase, seqnum)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "building reclaim preauth tx")
+	}
+	reclaimTxHash, err := reclaimTx.Hash()
+	if err != nil {
+		return "", 0, errors.Wrap(err, "hashing reclaim preauth tx")
+	}
+	reclaimHashStr, err := strkey.Encode(strkey.VersionByteHashTx, reclaimTxHash[:])
+	if err != nil {
+		return "", 0, errors.Wrap(err, "encoding reclaim preauth tx hash")
+	}
+
+	// A plain-Payment peg-out (claimable == false) can be rejected
+	// with op_no_trust if the exporter's trustline for asset
+	// disappears between pre-export and peg-out time. Preauthorizing
+	// the claimable-balance variant of the same row up front lets
+	// advancePegOut retry automatically with that variant instead of
+	// dead-ending in exportStateNeedsClaimable - see
+	// fallBackToClaimable. There's no fallback to preauthorize for an
+	// already-claimable export, or for the native asset, which has no
+	// trustline requirement and so can't hit op_no_trust in the first
+	// place.
+	var claimableFallbackHashStr string
+	if !claimable {
+		switch asset.Type {
+		case xdr.AssetTypeAssetTypeCreditAlphanum4, xdr.AssetTypeAssetTypeCreditAlphanum12:
+			fallbackTx, err := buildPegOutTx(custodian, kp.Address(), tempKP.Address(), root.NetworkPassphrase, asset, amount, seqnum, true)
+			if err != nil {
+				return "", 0, errors.Wrap(err, "building claimable-balance fallback preauth tx")
+			}
+			fallbackTxHash, err := fallbackTx.Hash()
+			if err != nil {
+				return "", 0, errors.Wrap(err, "hashing claimable-balance fallback preauth tx")
+			}
+			claimableFallbackHashStr, err = strkey.Encode(strkey.VersionByteHashTx, fallbackTxHash[:])
+			if err != nil {
+				return "", 0, errors.Wrap(err, "encoding claimable-balance fallback preauth tx hash")
+			}
+		}
+	}
+
+	setOptionsMuts := []b.SetOptionsBuilder{
+		b.SourceAccount{AddressOrSeed: tempKP.Address()},
+		b.MasterWeight(0),
+		b.SetThresholds(1, 1, 1),
+		b.AddSigner(hashStr, 1),
+		b.AddSigner(reclaimHashStr, 1),
+	}
+	if claimableFallbackHashStr != "" {
+		setOptionsMuts = append(setOptionsMuts, b.AddSigner(claimableFallbackHashStr, 1))
+	}
+
 	tx, err := b.Transaction(
 		b.Network{Passphrase: root.NetworkPassphrase},
 		b.SourceAccount{AddressOrSeed: kp.Address()},
 		b.AutoSequence{SequenceProvider: hclient},
 		b.BaseFee{Amount: baseFee},
-		b.SetOptions(
-			b.SourceAccount{AddressOrSeed: tempKP.Address()},
-			b.MasterWeight(0),
-			b.SetThresholds(1, 1, 1),
-			b.AddSigner(hashStr, 1),
-		),
+		b.SetOptions(setOptionsMuts...),
 	)
 	if err != nil {
 		return "", 0, errors.Wrap(err, "building pre-export tx")
@@ -281,7 +668,11 @@ func SubmitPreExportTx(hclient horizon.ClientInterface, kp *keypair.Full, custod
 // BuildExportTx builds a txvm retirement tx for an asset issued
 // onto slidechain. It will retire `amount` of the asset, and the
 // remaining input will be output back to the original account.
-func BuildExportTx(ctx context.Context, asset xdr.Asset, amount, inputAmt int64, tempAddr string, anchor []byte, prv ed25519.PrivateKey, seqnum xdr.SequenceNumber) (*bc.Tx, error) {
+// claimable must match whatever was passed to the SubmitPreExportTx
+// call that produced tempAddr, since it determines which peg-out
+// operation the custodian will build against the preauthorized
+// temp-account transaction.
+func BuildExportTx(ctx context.Context, asset xdr.Asset, amount, inputAmt int64, tempAddr string, anchor []byte, prv ed25519.PrivateKey, seqnum xdr.SequenceNumber, claimable bool) (*bc.Tx, error) {
 	if inputAmt < amount {
 		return nil, fmt.Errorf("cannot have input amount %d less than export amount %d", inputAmt, amount)
 	}
@@ -302,6 +693,7 @@ func BuildExportTx(ctx context.Context, asset xdr.Asset, amount, inputAmt int64,
 		tempAddr,
 		int64(seqnum),
 		kp.Address(),
+		claimable,
 	}
 	refdata, err := json.Marshal(ref)
 	if err != nil {
@@ -0,0 +1,272 @@
+package slidechain
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/interstellar/slingshot/slidechain/stellar"
+	b "github.com/stellar/go/build"
+	"github.com/stellar/go/xdr"
+)
+
+// defaultBatchSize and defaultMaxBatchWait are used when a
+// Custodian is constructed without explicit peg-out batching
+// settings. Stellar allows up to 100 operations per transaction;
+// we stay well under that so a single bad row never ties up an
+// outsized fee.
+const (
+	defaultBatchSize    = 20
+	defaultMaxBatchWait = 2 * time.Second
+)
+
+// PegOutBatchConfig tunes the latency-vs-throughput tradeoff of the
+// batched peg-out path: how many rows to fold into a single Stellar
+// transaction, and how long to wait for a batch to fill before
+// submitting whatever has accumulated.
+type PegOutBatchConfig struct {
+	BatchSize    int
+	MaxBatchWait time.Duration
+}
+
+// pegOutRow is one pending row from the exports table, shaped for
+// batching. tempID and seqnum identify the row's preauthorized temp
+// account so its reserve can be reclaimed (via buildPegOutReclaimTx)
+// once the batched payment below has landed.
+type pegOutRow struct {
+	txid     []byte
+	exporter xdr.AccountId
+	asset    xdr.Asset
+	amount   int64
+	tempID   xdr.AccountId
+	seqnum   xdr.SequenceNumber
+}
+
+// buildBatchedPegOutTx combines the payout half of each row's
+// peg-out into a single Stellar transaction sourced from the
+// custodian account, amortizing the round-trip to Horizon across
+// every row in the batch. The account-merge half of each row is
+// preauthorized per row at export time against the exact
+// merge-and-payout transaction buildPegOutTx builds, so it
+// can't be folded into this shared transaction; reclaimBatchedRow
+// recovers each row's temp account reserve separately, once this
+// payment has landed, via the merge-only preauth tx
+// buildPegOutReclaimTx builds.
+func buildBatchedPegOutTx(custodianAddr, network string, rows []pegOutRow, seqnum xdr.SequenceNumber) (*b.TransactionBuilder, error) {
+	if len(rows) == 0 {
+		return nil, errors.New("no rows to batch")
+	}
+	muts := []b.TransactionMutator{
+		b.Network{Passphrase: network},
+		b.SourceAccount{AddressOrSeed: custodianAddr},
+		b.Sequence{Sequence: uint64(seqnum) + 1},
+		b.BaseFee{Amount: baseFee},
+	}
+	for _, row := range rows {
+		paymentOp, err := buildPegOutPaymentOp(custodianAddr, row.exporter.Address(), row.asset, row.amount)
+		if err != nil {
+			return nil, errors.Wrapf(err, "building payment op for tx %x", row.txid)
+		}
+		muts = append(muts, paymentOp)
+	}
+	return b.Transaction(muts...)
+}
+
+// opResult is the per-operation outcome of a batched peg-out,
+// attributing a Horizon result code back to the row that produced
+// it.
+type opResult struct {
+	row  pegOutRow
+	code xdr.OperationResultCode
+	ok   bool
+}
+
+// decodeBatchResults walks the operation results embedded in a
+// submitted batch transaction's result XDR, in the same order the
+// rows were appended to the transaction by buildBatchedPegOutTx, so
+// that a partial failure only requeues the offending row instead of
+// the whole batch.
+func decodeBatchResults(resultXDR string, rows []pegOutRow) ([]opResult, error) {
+	var txResult xdr.TransactionResult
+	err := xdr.SafeUnmarshalBase64(resultXDR, &txResult)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshaling transaction result")
+	}
+	opResults, ok := txResult.Result.GetResults()
+	if !ok {
+		return nil, errors.New("transaction result carries no per-operation results")
+	}
+	codes := make([]xdr.OperationResultCode, len(opResults))
+	for i, r := range opResults {
+		codes[i] = r.Code
+	}
+	return attributeResults(codes, rows)
+}
+
+// attributeResults pairs each row with the result code of the
+// operation it produced, by position. It's split out from
+// decodeBatchResults so the attribution logic can be tested without
+// constructing real Horizon result XDR.
+func attributeResults(codes []xdr.OperationResultCode, rows []pegOutRow) ([]opResult, error) {
+	if len(codes) != len(rows) {
+		return nil, errors.New("operation result count does not match batch size")
+	}
+	results := make([]opResult, len(rows))
+	for i, row := range rows {
+		results[i] = opResult{
+			row:  row,
+			code: codes[i],
+			ok:   codes[i] == xdr.OperationResultCodeOpInner,
+		}
+	}
+	return results, nil
+}
+
+// batchPegOuts groups rows into batches of at most cfg.BatchSize and
+// submits one Stellar transaction per batch, one payment operation
+// per row. The Horizon calls take no context and are not
+// time-bounded here. Rows whose operation fails are left in
+// the exports table for the next pass; rows that succeed are
+// deleted.
+//
+// batchPegOuts itself does not wait for more rows to arrive - that's
+// pegOutBatcher's job, upstream in pegOutFromExports. By the time
+// rows reaches here, the accumulation window has already closed.
+func (c *Custodian) batchPegOuts(ctx context.Context, rows []pegOutRow, cfg PegOutBatchConfig) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.MaxBatchWait <= 0 {
+		cfg.MaxBatchWait = defaultMaxBatchWait
+	}
+
+	for start := 0; start < len(rows); start += cfg.BatchSize {
+		end := start + cfg.BatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := rows[start:end]
+
+		c.submitPegOutBatch(ctx, batch)
+	}
+}
+
+// pegOutBatcher accumulates pegOutRow candidates across successive
+// wakeups of pegOutFromExports and flushes them to flush once
+// cfg.BatchSize rows have accumulated, or cfg.MaxBatchWait has
+// elapsed since the first unflushed row arrived - whichever comes
+// first. This is what actually implements the "wait for a batch to
+// fill" latency-vs-throughput tradeoff PegOutBatchConfig describes;
+// batchPegOuts on its own only ever sees rows after this window has
+// already closed.
+type pegOutBatcher struct {
+	cfg   PegOutBatchConfig
+	flush func(rows []pegOutRow)
+
+	mu    sync.Mutex
+	rows  []pegOutRow
+	timer *time.Timer
+}
+
+func newPegOutBatcher(cfg PegOutBatchConfig, flush func(rows []pegOutRow)) *pegOutBatcher {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.MaxBatchWait <= 0 {
+		cfg.MaxBatchWait = defaultMaxBatchWait
+	}
+	return &pegOutBatcher{cfg: cfg, flush: flush}
+}
+
+// add appends rows to the accumulator, flushing immediately if
+// cfg.BatchSize is reached and otherwise arming a timer (if one
+// isn't already running) that flushes after cfg.MaxBatchWait.
+func (p *pegOutBatcher) add(rows []pegOutRow) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rows = append(p.rows, rows...)
+	if len(p.rows) >= p.cfg.BatchSize {
+		p.flushLocked()
+		return
+	}
+	if p.timer == nil {
+		p.timer = time.AfterFunc(p.cfg.MaxBatchWait, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.flushLocked()
+		})
+	}
+}
+
+// flushLocked hands off every accumulated row to flush and resets
+// the accumulator. Callers must hold p.mu.
+func (p *pegOutBatcher) flushLocked() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if len(p.rows) == 0 {
+		return
+	}
+	rows := p.rows
+	p.rows = nil
+	go p.flush(rows)
+}
+
+func (c *Custodian) submitPegOutBatch(ctx context.Context, batch []pegOutRow) {
+	seqnum, err := c.hclient.SequenceForAccount(c.AccountID.Address())
+	if err != nil {
+		log.Printf("getting custodian sequence number for batched peg-out: %s", err)
+		return
+	}
+	tx, err := buildBatchedPegOutTx(c.AccountID.Address(), c.network, batch, seqnum)
+	if err != nil {
+		log.Printf("building batched peg-out tx: %s", err)
+		return
+	}
+	succ, err := stellar.SignAndSubmitTx(c.hclient, tx, c.seed)
+	if err != nil {
+		log.Printf("submitting batched peg-out tx for %d rows: %s", len(batch), err)
+		return
+	}
+	results, err := decodeBatchResults(succ.Result, batch)
+	if err != nil {
+		log.Printf("decoding batched peg-out results: %s", err)
+		return
+	}
+	for _, r := range results {
+		if !r.ok {
+			log.Printf("peg-out op for tx %x failed with code %s, leaving for retry", r.row.txid, r.code)
+			continue
+		}
+		c.reclaimBatchedRow(ctx, r.row)
+		_, err = c.DB.ExecContext(ctx, `DELETE FROM exports WHERE txid=$1`, r.row.txid)
+		if err != nil {
+			log.Printf("deleting successfully pegged-out export %x: %s", r.row.txid, err)
+		}
+	}
+}
+
+// reclaimBatchedRow submits row's merge-only preauthorized tx
+// (built by buildPegOutReclaimTx, the same tx whose hash was
+// registered as a signer on the temp account back in
+// SubmitPreExportTx) now that the batched payment above has already
+// paid the exporter out of the custodian account. This is best
+// effort: row's payout has already landed by the time this runs, so
+// a failure here (e.g. the temp account was already merged by some
+// other path) only costs the temp account's 2 XLM reserve, not the
+// export itself, and is logged rather than left for retry.
+func (c *Custodian) reclaimBatchedRow(ctx context.Context, row pegOutRow) {
+	tx, err := buildPegOutReclaimTx(row.exporter.Address(), row.tempID.Address(), c.network, row.seqnum)
+	if err != nil {
+		log.Printf("building reclaim tx for temp account %s: %s", row.tempID.Address(), err)
+		return
+	}
+	_, err = stellar.SignAndSubmitTx(c.hclient, tx, c.seed)
+	if err != nil {
+		log.Printf("reclaiming temp account %s reserve: %s", row.tempID.Address(), err)
+	}
+}
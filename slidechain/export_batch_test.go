@@ -0,0 +1,128 @@
+package slidechain
+
+import (
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/xdr"
+)
+
+func TestBuildBatchedPegOutTxFeeAmortization(t *testing.T) {
+	custodian, err := keypair.Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lumen xdr.Asset
+	lumen.Type = xdr.AssetTypeAssetTypeNative
+
+	var rows []pegOutRow
+	for i := 0; i < 5; i++ {
+		exporterKP, err := keypair.Random()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var exporter xdr.AccountId
+		err = exporter.SetAddress(exporterKP.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, pegOutRow{
+			txid:     []byte{byte(i)},
+			exporter: exporter,
+			asset:    lumen,
+			amount:   int64(10 * (i + 1)),
+		})
+	}
+
+	tx, err := buildBatchedPegOutTx(custodian.Address(), "Test SDF Network ; September 2015", rows, xdr.SequenceNumber(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(tx.TX.Operations); got != len(rows) {
+		t.Fatalf("got %d operations, want %d", got, len(rows))
+	}
+	// Stellar charges the base fee per operation, and the batch carries
+	// exactly one payment op per row, so the single transaction costs
+	// one base fee per row.
+	if got, want := int64(tx.TX.Fee), int64(baseFee*len(rows)); got != want {
+		t.Fatalf("got fee %d, want %d (one base fee per row)", got, want)
+	}
+}
+
+func TestBuildBatchedPegOutTxEmpty(t *testing.T) {
+	custodian, err := keypair.Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = buildBatchedPegOutTx(custodian.Address(), "Test SDF Network ; September 2015", nil, xdr.SequenceNumber(1))
+	if err == nil {
+		t.Fatal("expected error building a batch of zero rows")
+	}
+}
+
+func TestAttributeResults(t *testing.T) {
+	rows := []pegOutRow{
+		{txid: []byte("a")},
+		{txid: []byte("b")},
+		{txid: []byte("c")},
+	}
+	codes := []xdr.OperationResultCode{
+		xdr.OperationResultCodeOpInner,
+		xdr.OperationResultCodeOpBadAuth,
+		xdr.OperationResultCodeOpInner,
+	}
+	results, err := attributeResults(codes, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, false, true}
+	for i, r := range results {
+		if r.ok != want[i] {
+			t.Errorf("row %d: got ok=%v, want %v", i, r.ok, want[i])
+		}
+		if string(r.row.txid) != string(rows[i].txid) {
+			t.Errorf("row %d: result attributed to wrong row: got %x, want %x", i, r.row.txid, rows[i].txid)
+		}
+	}
+}
+
+func TestAttributeResultsCountMismatch(t *testing.T) {
+	rows := []pegOutRow{{txid: []byte("a")}, {txid: []byte("b")}}
+	codes := []xdr.OperationResultCode{xdr.OperationResultCodeOpInner}
+	_, err := attributeResults(codes, rows)
+	if err == nil {
+		t.Fatal("expected error on result/row count mismatch")
+	}
+}
+
+// TestBuildPegOutReclaimTx checks that the merge-only tx reclaimBatchedRow
+// submits to recover a batched row's temp account reserve carries
+// nothing but the account merge - no payout op, since the batched
+// payment has already paid the exporter separately.
+func TestBuildPegOutReclaimTx(t *testing.T) {
+	exporter, err := keypair.Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp, err := keypair.Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := buildPegOutReclaimTx(exporter.Address(), temp.Address(), "Test SDF Network ; September 2015", xdr.SequenceNumber(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(tx.TX.Operations); got != 1 {
+		t.Fatalf("got %d operations, want 1 (account merge only)", got)
+	}
+	op := tx.TX.Operations[0]
+	if op.Body.Type != xdr.OperationTypeAccountMerge {
+		t.Fatalf("wrong operation type: got %s, want %s", op.Body.Type, xdr.OperationTypeAccountMerge)
+	}
+	if got := op.Body.Destination.Address(); got != exporter.Address() {
+		t.Fatalf("wrong account merge destination: got %s, want %s", got, exporter.Address())
+	}
+	if got := tx.TX.SourceAccount.Address(); got != temp.Address() {
+		t.Fatalf("got source account %s, want temp account %s", got, temp.Address())
+	}
+}
@@ -0,0 +1,172 @@
+package slidechain
+
+import (
+	"encoding/hex"
+
+	"github.com/chain/txvm/errors"
+	"github.com/interstellar/slingshot/slidechain/stellar"
+	b "github.com/stellar/go/build"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/xdr"
+)
+
+// opNoTrustCode is the Horizon operation result code returned when
+// a Payment op targets an account with no trustline for a
+// non-native asset.
+const opNoTrustCode = "op_no_trust"
+
+// buildPegOutClaimableBalanceOp builds the CreateClaimableBalance
+// operation used in place of a direct Payment when the exporter
+// doesn't hold (or is suspected not to hold) a trustline for asset.
+// exporterAddr is named as the balance's sole claimant with an
+// unconditional predicate, so they can claim it at their own pace
+// once they've established a trustline; custodianAddr pays for it,
+// matching the Payment op it replaces.
+func buildPegOutClaimableBalanceOp(custodianAddr, exporterAddr string, asset xdr.Asset, amount int64) (b.TransactionMutator, error) {
+	switch asset.Type {
+	case xdr.AssetTypeAssetTypeCreditAlphanum4, xdr.AssetTypeAssetTypeCreditAlphanum12:
+	default:
+		return nil, errors.New("claimable-balance peg-out is only supported for credit assets")
+	}
+
+	var exporter xdr.AccountId
+	err := exporter.SetAddress(exporterAddr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing exporter address %s", exporterAddr)
+	}
+	var source xdr.AccountId
+	err = source.SetAddress(custodianAddr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing custodian address %s", custodianAddr)
+	}
+	sourceMux := source.ToMuxedAccount()
+
+	op := xdr.CreateClaimableBalanceOp{
+		Asset:  asset,
+		Amount: xdr.Int64(amount),
+		Claimants: []xdr.Claimant{{
+			Type: xdr.ClaimantTypeClaimantTypeV0,
+			V0: &xdr.ClaimantV0{
+				Destination: exporter,
+				Predicate:   xdr.ClaimPredicate{Type: xdr.ClaimPredicateTypeClaimPredicateUnconditional},
+			},
+		}},
+	}
+	return rawOp{xdr.Operation{
+		SourceAccount: &sourceMux,
+		Body: xdr.OperationBody{
+			Type:                     xdr.OperationTypeCreateClaimableBalance,
+			CreateClaimableBalanceOp: &op,
+		},
+	}}, nil
+}
+
+// rawOp splices an xdr.Operation into a b.TransactionBuilder
+// alongside the ops the build package does have constructors for.
+// It exists because the build package predates CAP-23 claimable
+// balances (much as fee-bump transactions, added by CAP-15, are
+// built by hand in fee_bump.go instead of through a b.* mutator).
+type rawOp struct {
+	op xdr.Operation
+}
+
+func (r rawOp) MutateTransaction(tx *b.TransactionBuilder) error {
+	tx.TX.Operations = append(tx.TX.Operations, r.op)
+	return nil
+}
+
+// isNoTrustline reports whether err is a Horizon rejection of a
+// Payment operation because the destination has no trustline for
+// the asset being sent.
+func isNoTrustline(err error) bool {
+	herr, ok := errors.Root(err).(*horizon.Error)
+	if !ok {
+		return false
+	}
+	resultCodes, err := herr.ResultCodes()
+	if err != nil {
+		return false
+	}
+	for _, code := range resultCodes.OperationCodes {
+		if code == opNoTrustCode {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeClaimableBalanceID extracts the id of the claimable balance
+// created by a successful peg-out transaction from its base64
+// result XDR, for storage alongside the export row so ClaimPeggedOut
+// has something to look up later.
+func decodeClaimableBalanceID(resultXDR string) (string, error) {
+	var txResult xdr.TransactionResult
+	err := xdr.SafeUnmarshalBase64(resultXDR, &txResult)
+	if err != nil {
+		return "", errors.Wrap(err, "unmarshaling transaction result")
+	}
+	opResults, ok := txResult.Result.GetResults()
+	if !ok {
+		return "", errors.New("transaction result carries no per-operation results")
+	}
+	for _, r := range opResults {
+		tr, ok := r.Tr.GetCreateClaimableBalanceResult()
+		if !ok {
+			continue
+		}
+		balanceID, ok := tr.GetBalanceId()
+		if !ok {
+			continue
+		}
+		raw, err := balanceID.MarshalBinary()
+		if err != nil {
+			return "", errors.Wrap(err, "marshaling claimable balance id")
+		}
+		return hex.EncodeToString(raw), nil
+	}
+	return "", errors.New("no CreateClaimableBalance result found")
+}
+
+// ClaimPeggedOut claims a claimable balance that the custodian
+// created in place of a direct payment, typically because kp had no
+// trustline for the asset at the time of the peg-out. balanceID is
+// the hex-encoded ClaimableBalanceId recorded against the export
+// (see markExportClaimable). kp must already hold a trustline for
+// the balance's asset by the time this is called.
+func ClaimPeggedOut(hclient horizon.ClientInterface, kp *keypair.Full, balanceID string) error {
+	raw, err := hex.DecodeString(balanceID)
+	if err != nil {
+		return errors.Wrapf(err, "decoding claimable balance id %s", balanceID)
+	}
+	var id xdr.ClaimableBalanceId
+	err = xdr.SafeUnmarshal(raw, &id)
+	if err != nil {
+		return errors.Wrap(err, "unmarshaling claimable balance id")
+	}
+
+	root, err := hclient.Root()
+	if err != nil {
+		return errors.Wrap(err, "getting Horizon root")
+	}
+	tx, err := b.Transaction(
+		b.Network{Passphrase: root.NetworkPassphrase},
+		b.SourceAccount{AddressOrSeed: kp.Address()},
+		b.AutoSequence{SequenceProvider: hclient},
+		b.BaseFee{Amount: baseFee},
+		rawOp{xdr.Operation{
+			Body: xdr.OperationBody{
+				Type:                    xdr.OperationTypeClaimClaimableBalance,
+				ClaimClaimableBalanceOp: &xdr.ClaimClaimableBalanceOp{BalanceId: id},
+			},
+		}},
+	)
+	if err != nil {
+		return errors.Wrap(err, "building claim-claimable-balance tx")
+	}
+	_, err = stellar.SignAndSubmitTx(hclient, tx, kp.Seed())
+	if err != nil {
+		return errors.Wrapf(err, "submitting claim for balance %s", balanceID)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package slidechain
+
+import (
+	"testing"
+
+	"github.com/stellar/go/xdr"
+)
+
+func TestBuildPegOutClaimableBalanceOpRejectsNative(t *testing.T) {
+	_, err := buildPegOutClaimableBalanceOp(
+		"GAQLEQ36ZYMCZQCKFIWWKB7LXSDPEEHQBMWDO5HLNKQI7ZJKYFOCHDSX",
+		"GAQLEQ36ZYMCZQCKFIWWKB7LXSDPEEHQBMWDO5HLNKQI7ZJKYFOCHDSX",
+		xdr.Asset{Type: xdr.AssetTypeAssetTypeNative},
+		100,
+	)
+	if err == nil {
+		t.Fatal("expected an error for a native asset, got nil")
+	}
+}
+
+func TestDecodeClaimableBalanceIDRejectsGarbage(t *testing.T) {
+	_, err := decodeClaimableBalanceID("not valid base64 xdr")
+	if err == nil {
+		t.Fatal("expected an error decoding garbage result XDR, got nil")
+	}
+}
+
+// TestFallBackToClaimableBuildsSameTxAsPreauth calls buildPegOutTx
+// twice in claimable mode with the same arguments - the call
+// fallBackToClaimable makes on an op_no_trust retry and the one
+// SubmitPreExportTx makes to preauthorize that variant both have
+// this form - and checks the two hashes match. It calls neither
+// function itself. If the hashes differed, the retry wouldn't match
+// the temp account's preauthorized signer and Horizon would reject it.
+func TestFallBackToClaimableBuildsSameTxAsPreauth(t *testing.T) {
+	asset := xdr.Asset{
+		Type: xdr.AssetTypeAssetTypeCreditAlphanum4,
+		AlphaNum4: &xdr.AssetAlphaNum4{
+			AssetCode: xdr.AssetCode4{'A', 'B', 'C', 'D'},
+			Issuer:    mustAccountID("GAQLEQ36ZYMCZQCKFIWWKB7LXSDPEEHQBMWDO5HLNKQI7ZJKYFOCHDSX"),
+		},
+	}
+	const (
+		custodian = "GAQLEQ36ZYMCZQCKFIWWKB7LXSDPEEHQBMWDO5HLNKQI7ZJKYFOCHDSX"
+		exporter  = "GBRPYHIL2CI3FNQ4BXLFMNDLFJUNPU2HY3ZMFSHONUCEOASW7QC7OX2H"
+		temp      = "GDKIJJIKXLOM2NRMPNQZUUYK24ZPVFC6426GZAEP3KUK6KEJLACCWNMX"
+	)
+	seqnum := xdr.SequenceNumber(1)
+
+	preauth, err := buildPegOutTx(custodian, exporter, temp, "Test SDF Network ; September 2015", asset, 100, seqnum, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, err := buildPegOutTx(custodian, exporter, temp, "Test SDF Network ; September 2015", asset, 100, seqnum, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preauthHash, err := preauth.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	retryHash, err := retry.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preauthHash != retryHash {
+		t.Fatal("claimable-balance fallback tx doesn't match the preauthorized tx hash")
+	}
+}
+
+func mustAccountID(addr string) xdr.AccountId {
+	var id xdr.AccountId
+	if err := id.SetAddress(addr); err != nil {
+		panic(err)
+	}
+	return id
+}
@@ -0,0 +1,83 @@
+package slidechain
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/stellar/go/xdr"
+)
+
+// pegOutAttempt bundles everything advancePegOut needs to drive a
+// single exports row, including the bookkeeping columns
+// (state/attempts/stellar_tx_hash) that make the peg-out loop
+// crash-safe.
+type pegOutAttempt struct {
+	txid        []byte
+	exporter    xdr.AccountId
+	asset       xdr.Asset
+	amount      int64
+	tempID      xdr.AccountId
+	seqnum      xdr.SequenceNumber
+	state       string
+	attempts    int
+	txHash      string
+	fee         int64
+	submittedAt sql.NullTime
+	claimable   bool
+}
+
+const (
+	pegOutBackoffBase = 500 * time.Millisecond
+	pegOutBackoffMax  = 5 * time.Minute
+)
+
+// pegOutBackoff returns how long to wait before the next submission
+// attempt for a row, growing exponentially with the number of prior
+// attempts and capped at pegOutBackoffMax.
+func pegOutBackoff(attempts int) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+	d := pegOutBackoffBase
+	for i := 0; i < attempts && d < pegOutBackoffMax; i++ {
+		d *= 2
+	}
+	if d > pegOutBackoffMax {
+		d = pegOutBackoffMax
+	}
+	return d
+}
+
+// inflightSet is a small in-memory keyed singleflight: it lets
+// pegOutFromExports guarantee that at most one goroutine is ever
+// advancing a given export row at a time, even if the exports
+// condition variable fires again (or another wakeup races in)
+// before the previous pass for that row has finished.
+type inflightSet struct {
+	mu  sync.Mutex
+	set map[string]struct{}
+}
+
+// start claims key for the caller. It reports false if key is
+// already claimed, in which case the caller must not start a
+// duplicate attempt.
+func (s *inflightSet) start(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.set == nil {
+		s.set = make(map[string]struct{})
+	}
+	if _, ok := s.set[key]; ok {
+		return false
+	}
+	s.set[key] = struct{}{}
+	return true
+}
+
+// done releases a key previously claimed with start.
+func (s *inflightSet) done(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.set, key)
+}
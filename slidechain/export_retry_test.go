@@ -0,0 +1,37 @@
+package slidechain
+
+import "testing"
+
+func TestPegOutBackoffGrows(t *testing.T) {
+	if d := pegOutBackoff(0); d != 0 {
+		t.Errorf("got backoff %s for a first attempt, want 0", d)
+	}
+	prev := pegOutBackoff(1)
+	for attempt := 2; attempt < 10; attempt++ {
+		d := pegOutBackoff(attempt)
+		if d < prev {
+			t.Fatalf("backoff decreased at attempt %d: got %s, previous %s", attempt, d, prev)
+		}
+		prev = d
+	}
+	if got := pegOutBackoff(100); got != pegOutBackoffMax {
+		t.Errorf("got backoff %s for a large attempt count, want cap %s", got, pegOutBackoffMax)
+	}
+}
+
+func TestInflightSetPreventsDuplicateClaims(t *testing.T) {
+	var s inflightSet
+	if !s.start("a") {
+		t.Fatal("expected first claim of key a to succeed")
+	}
+	if s.start("a") {
+		t.Fatal("expected second claim of key a to fail while still in flight")
+	}
+	if !s.start("b") {
+		t.Fatal("expected claim of a distinct key to succeed")
+	}
+	s.done("a")
+	if !s.start("a") {
+		t.Fatal("expected claim of key a to succeed again once released")
+	}
+}
@@ -0,0 +1,169 @@
+package slidechain
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txvm"
+	"github.com/stellar/go/strkey"
+	"github.com/stellar/go/xdr"
+)
+
+// validateExport re-derives and checks everything watchExports is
+// about to trust from a retirement's refdata before admitting it
+// into the exports table: that the asset actually matches what was
+// retired, that the temp account named in refdata is genuinely
+// configured for this exact peg-out, that the custodian can still
+// pay it, and that the temp account's preauthorized transaction
+// hasn't already been consumed. tx is the retiring transaction; it
+// is not consulted. Everything checked comes from info,
+// retiredAssetIDBytes, amount, and Horizon.
+func (c *Custodian) validateExport(ctx context.Context, tx *bc.Tx, info pegOut, retiredAssetIDBytes []byte, amount int64) error {
+	gotAssetID := txvm.AssetID(importIssuanceSeed[:], info.AssetXDR)
+	if !bytes.Equal(gotAssetID[:], retiredAssetIDBytes) {
+		return errors.New("retired asset id does not match the asset XDR in refdata")
+	}
+
+	var asset xdr.Asset
+	err := xdr.SafeUnmarshal(info.AssetXDR, &asset)
+	if err != nil {
+		return errors.Wrap(err, "unmarshaling asset XDR")
+	}
+
+	err = c.validateTempAccount(info, asset, amount)
+	if err != nil {
+		return errors.Wrap(err, "validating temp account")
+	}
+
+	err = c.validateCustodianCanPay(asset, amount)
+	if err != nil {
+		return errors.Wrap(err, "validating custodian balance")
+	}
+
+	return nil
+}
+
+// validateTempAccount confirms that the temp account named in info
+// is configured exactly the way SubmitPreExportTx would have left
+// it for this specific peg-out: master weight 0, a preauth signer
+// whose hash matches the peg-out tx this row would actually submit
+// (any other signers, such as the reclaim and claimable-fallback
+// preauth signers SubmitPreExportTx also adds, are not checked), and
+// a sequence number that hasn't already moved past the
+// one recorded in refdata (which would mean the preauthorized
+// transaction was already consumed, or never matched what's on
+// Horizon in the first place).
+func (c *Custodian) validateTempAccount(info pegOut, asset xdr.Asset, amount int64) error {
+	account, err := c.hclient.LoadAccount(info.TempAddr)
+	if err != nil {
+		return errors.Wrapf(err, "loading temp account %s", info.TempAddr)
+	}
+
+	if strconv.FormatInt(info.Seqnum, 10) != account.Sequence {
+		return errors.New("temp account sequence number does not match refdata, preauth tx already consumed")
+	}
+
+	preauthTx, err := buildPegOutTx(c.AccountID.Address(), info.Exporter, info.TempAddr, c.network, asset, amount, xdr.SequenceNumber(info.Seqnum), info.Claimable)
+	if err != nil {
+		return errors.Wrap(err, "rebuilding expected peg-out tx")
+	}
+	preauthHash, err := preauthTx.Hash()
+	if err != nil {
+		return errors.Wrap(err, "hashing expected peg-out tx")
+	}
+	wantSigner, err := strkey.Encode(strkey.VersionByteHashTx, preauthHash[:])
+	if err != nil {
+		return errors.Wrap(err, "encoding expected preauth signer")
+	}
+
+	var gotMasterWeight int32 = -1
+	var gotPreauthWeight int32 = -1
+	for _, signer := range account.Signers {
+		switch signer.Key {
+		case info.TempAddr:
+			gotMasterWeight = signer.Weight
+		case wantSigner:
+			gotPreauthWeight = signer.Weight
+		}
+	}
+	if gotMasterWeight != 0 {
+		return errors.New("temp account master weight is not 0")
+	}
+	if gotPreauthWeight < 1 {
+		return errors.New("temp account is missing the expected preauth signer")
+	}
+	return nil
+}
+
+// validateCustodianCanPay confirms the custodian's own Stellar
+// account still holds enough of asset to cover the payout - it
+// needs a balance (and, for non-native assets, a trustline) large
+// enough regardless of whether the payout ends up as a direct
+// Payment or a claimable balance, since either way the funds come
+// out of the custodian's own account.
+func (c *Custodian) validateCustodianCanPay(asset xdr.Asset, amount int64) error {
+	account, err := c.hclient.LoadAccount(c.AccountID.Address())
+	if err != nil {
+		return errors.Wrap(err, "loading custodian account")
+	}
+	switch asset.Type {
+	case xdr.AssetTypeAssetTypeNative:
+		balance, err := account.GetNativeBalance()
+		if err != nil {
+			return errors.Wrap(err, "getting custodian native balance")
+		}
+		return checkBalanceCovers(balance, amount)
+	case xdr.AssetTypeAssetTypeCreditAlphanum4:
+		code := string(asset.AlphaNum4.AssetCode[:])
+		balance := account.GetCreditBalance(code, asset.AlphaNum4.Issuer.Address())
+		return checkBalanceCovers(balance, amount)
+	case xdr.AssetTypeAssetTypeCreditAlphanum12:
+		code := string(asset.AlphaNum12.AssetCode[:])
+		balance := account.GetCreditBalance(code, asset.AlphaNum12.Issuer.Address())
+		return checkBalanceCovers(balance, amount)
+	}
+	return errors.New("unsupported asset type")
+}
+
+// checkBalanceCovers reports an error if the (decimal-string)
+// balance Horizon reports is less than amount, Stellar's native
+// integer unit.
+func checkBalanceCovers(balance string, amount int64) error {
+	if balance == "" {
+		return errors.New("custodian has no trustline for asset")
+	}
+	have, err := parseHorizonAmount(balance)
+	if err != nil {
+		return errors.Wrapf(err, "parsing balance %q", balance)
+	}
+	if have < amount {
+		return errors.New("custodian balance is insufficient to cover the payout")
+	}
+	return nil
+}
+
+// parseHorizonAmount converts one of Horizon's fixed-point decimal
+// amount strings (always 7 fractional digits) into the same raw
+// integer unit that amounts are recorded in everywhere else in this
+// package.
+func parseHorizonAmount(s string) (int64, error) {
+	whole := s
+	var frac string
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	frac = (frac + "0000000")[:7]
+	w, err := strconv.ParseInt(whole, 10, 63)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseInt(frac, 10, 63)
+	if err != nil {
+		return 0, err
+	}
+	return w*10_000_000 + f, nil
+}
@@ -0,0 +1,29 @@
+package slidechain
+
+import "testing"
+
+func TestParseHorizonAmount(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"100", 100 * 10_000_000},
+		{"0.0000001", 1},
+		{"12.5", 12*10_000_000 + 5_000_000},
+	}
+	for _, c := range cases {
+		got, err := parseHorizonAmount(c.in)
+		if err != nil {
+			t.Fatalf("parseHorizonAmount(%q): %s", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("parseHorizonAmount(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestCheckBalanceCoversNoTrustline(t *testing.T) {
+	if err := checkBalanceCovers("", 1); err == nil {
+		t.Fatal("expected an error for an empty balance, got nil")
+	}
+}
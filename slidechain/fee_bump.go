@@ -0,0 +1,136 @@
+package slidechain
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/xdr"
+)
+
+// feeBumpCount tracks how many times the custodian has had to
+// escalate a peg-out's fee via a CAP-0015 fee-bump transaction, so
+// operators can alert on a network fee spike before it freezes
+// funds in temp accounts.
+var feeBumpCount = expvar.NewInt("slidechain_pegout_fee_bumps")
+
+const (
+	// feeBumpCeiling is the most the custodian will ever offer as the
+	// total Fee of the fee-bump transaction wrapping a single export's
+	// peg-out,
+	// beyond which it gives up escalating and leaves the row for an
+	// operator to look at.
+	feeBumpCeiling = 10_000
+
+	// pegOutDeadline is how long a submitted-but-unconfirmed peg-out
+	// is given before it's considered stuck and a candidate for a fee
+	// bump, even without an explicit tx_insufficient_fee response.
+	pegOutDeadline = 2 * time.Minute
+)
+
+// nextBumpedFee escalates a stuck or underpriced peg-out's fee by
+// doubling it, capped at feeBumpCeiling.
+func nextBumpedFee(currentFee int64) int64 {
+	if currentFee <= 0 {
+		currentFee = baseFee
+	}
+	next := currentFee * 2
+	if next > feeBumpCeiling {
+		next = feeBumpCeiling
+	}
+	return next
+}
+
+// bumpPegOutFee wraps the (immutable, preauth-committed) peg-out
+// transaction for row in a CAP-0015 fee-bump transaction paying
+// newFee, signs the wrapper with the custodian's own key, and
+// resubmits it to Horizon. The inner transaction - and therefore the
+// preauth signer set on the temp account during SubmitPreExportTx -
+// is untouched: only the outer, custodian-signed envelope pays a
+// higher fee. It also refreshes submitted_at, so advancePegOut's
+// deadline check gives this new attempt a fresh pegOutDeadline
+// window instead of immediately re-escalating on the next wakeup.
+func (c *Custodian) bumpPegOutFee(ctx context.Context, row pegOutAttempt, newFee int64) error {
+	innerTx, err := buildPegOutTx(c.AccountID.Address(), row.exporter.Address(), row.tempID.Address(), c.network, row.asset, row.amount, row.seqnum, row.claimable)
+	if err != nil {
+		return errors.Wrap(err, "rebuilding inner peg-out tx")
+	}
+	innerEnv, err := innerTx.Sign(c.seed)
+	if err != nil {
+		return errors.Wrap(err, "signing inner peg-out tx")
+	}
+
+	rawKP, err := keypair.Parse(c.seed)
+	if err != nil {
+		return errors.Wrap(err, "parsing custodian seed")
+	}
+	custodianKP, ok := rawKP.(*keypair.Full)
+	if !ok {
+		return errors.New("custodian seed is not a full keypair")
+	}
+
+	feeBumpTx := xdr.FeeBumpTransaction{
+		FeeSource: custodianKP.FromAddress().ToMuxedAccount(),
+		Fee:       xdr.Int64(newFee),
+		InnerTx: xdr.FeeBumpTransactionInnerTx{
+			Type: xdr.EnvelopeTypeEnvelopeTypeTx,
+			V1:   innerEnv.V1,
+		},
+	}
+	feeBumpHash, err := network.HashFeeBumpTransaction(feeBumpTx, c.network)
+	if err != nil {
+		return errors.Wrap(err, "hashing fee-bump tx")
+	}
+	sig, err := custodianKP.SignDecorated(feeBumpHash[:])
+	if err != nil {
+		return errors.Wrap(err, "signing fee-bump tx")
+	}
+
+	env := xdr.TransactionEnvelope{
+		Type: xdr.EnvelopeTypeEnvelopeTypeTxFeeBump,
+		FeeBump: &xdr.FeeBumpTransactionEnvelope{
+			Tx:         feeBumpTx,
+			Signatures: []xdr.DecoratedSignature{sig},
+		},
+	}
+	envXDR, err := xdr.MarshalBase64(env)
+	if err != nil {
+		return errors.Wrap(err, "marshaling fee-bump envelope")
+	}
+
+	_, err = c.hclient.SubmitTransaction(envXDR)
+	if err != nil {
+		return errors.Wrapf(err, "submitting fee-bump tx for export %x", row.txid)
+	}
+
+	feeBumpCount.Add(1)
+	_, err = c.DB.ExecContext(ctx, `INSERT INTO fee_bumps (txid, old_fee, new_fee, bumped_at) VALUES ($1, $2, $3, $4)`, row.txid, row.fee, newFee, time.Now())
+	if err != nil {
+		log.Printf("recording fee bump for export %x: %s", row.txid, err)
+	}
+	_, err = c.DB.ExecContext(ctx, `UPDATE exports SET fee=$1, submitted_at=$2 WHERE txid=$3`, newFee, time.Now(), row.txid)
+	if err != nil {
+		log.Printf("updating fee for export %x: %s", row.txid, err)
+	}
+	return nil
+}
+
+// isInsufficientFee reports whether err is a Horizon rejection
+// caused by the network's minimum fee rising above what the peg-out
+// transaction offered.
+func isInsufficientFee(err error) bool {
+	herr, ok := errors.Root(err).(*horizon.Error)
+	if !ok {
+		return false
+	}
+	resultCodes, err := herr.ResultCodes()
+	if err != nil {
+		return false
+	}
+	return resultCodes.TransactionCode == xdr.TransactionResultCodeTxInsufficientFee.String()
+}
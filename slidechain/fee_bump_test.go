@@ -0,0 +1,19 @@
+package slidechain
+
+import "testing"
+
+func TestNextBumpedFee(t *testing.T) {
+	cases := []struct {
+		current, want int64
+	}{
+		{0, baseFee * 2},
+		{baseFee, baseFee * 2},
+		{feeBumpCeiling, feeBumpCeiling},
+		{feeBumpCeiling / 2, feeBumpCeiling},
+	}
+	for _, c := range cases {
+		if got := nextBumpedFee(c.current); got != c.want {
+			t.Errorf("nextBumpedFee(%d) = %d, want %d", c.current, got, c.want)
+		}
+	}
+}
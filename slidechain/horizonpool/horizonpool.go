@@ -0,0 +1,258 @@
+// Package horizonpool wraps one or more Horizon endpoints behind a
+// single horizon.ClientInterface, so an outage at the active
+// endpoint doesn't stall watchPegIns: requests fail over to a
+// standby endpoint instead of retrying the same dead one forever.
+package horizonpool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	i10rnet "github.com/interstellar/starlight/net"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/stellar/go/xdr"
+)
+
+// Config tunes failover behavior.
+type Config struct {
+	// FailoverWindow is how long the active endpoint may keep
+	// erroring before the pool promotes the next one. Defaults to 30
+	// seconds if zero.
+	FailoverWindow time.Duration
+}
+
+const defaultFailoverWindow = 30 * time.Second
+
+// Pool sends every request to one active endpoint out of a fixed set
+// of Horizon endpoints, all believed to be serving the same network,
+// and promotes the next endpoint (wrapping around) when the active
+// one errors continuously for longer than FailoverWindow. Requests
+// are not spread across the standby endpoints.
+//
+// Pool embeds horizon.ClientInterface so that any method this
+// package doesn't otherwise override is still proxied to whatever
+// endpoint is currently active, but every method slidechain actually
+// calls - LoadAccount, LoadTransaction, SequenceForAccount, Root,
+// SubmitTransaction, and StreamTransactions - is overridden below,
+// both to read the active endpoint under p.mu (promote() writes the
+// embedded field from another goroutine, so an unguarded read races
+// it) and, for StreamTransactions and SubmitTransaction, to add the
+// retry/failover loop.
+type Pool struct {
+	horizon.ClientInterface
+
+	mu       sync.Mutex
+	urls     []string
+	clients  []horizon.ClientInterface
+	active   int
+	failedAt time.Time // zero if the active endpoint is currently healthy
+	window   time.Duration
+	backoff  i10rnet.Backoff
+}
+
+// New returns a Pool over the given Horizon endpoint URLs. At least
+// one URL is required.
+func New(urls []string, cfg Config) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("horizonpool: no endpoint URLs given")
+	}
+	window := cfg.FailoverWindow
+	if window <= 0 {
+		window = defaultFailoverWindow
+	}
+	clients := make([]horizon.ClientInterface, len(urls))
+	for i, u := range urls {
+		clients[i] = &horizon.Client{
+			URL:  strings.TrimRight(u, "/"),
+			HTTP: new(http.Client),
+		}
+	}
+	p := &Pool{
+		urls:    urls,
+		clients: clients,
+		window:  window,
+		backoff: i10rnet.Backoff{Base: 100 * time.Millisecond},
+	}
+	p.ClientInterface = clients[0]
+	return p, nil
+}
+
+// StreamTransactions streams transactions for account from the
+// active endpoint, the same as horizon.Client.StreamTransactions,
+// except that an endpoint which errors continuously for longer than
+// the pool's FailoverWindow is replaced by the next one in the pool
+// before the call is retried.
+func (p *Pool) StreamTransactions(ctx context.Context, account string, cursor *horizon.Cursor, handler func(horizon.Transaction)) error {
+	for {
+		client, failoverWindowElapsed := p.activeClient()
+		if failoverWindowElapsed {
+			p.promote()
+			continue
+		}
+
+		err := client.StreamTransactions(ctx, account, cursor, handler)
+		if err == nil || err == context.Canceled {
+			p.clearFailure()
+			return err
+		}
+		p.markFailure()
+
+		ch := make(chan struct{})
+		go func() {
+			time.Sleep(p.backoff.Next())
+			close(ch)
+		}()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// SubmitTransaction submits txeBase64 via the active endpoint, the
+// same as horizon.Client.SubmitTransaction, failing over and
+// retrying on the same schedule as StreamTransactions. A submitted
+// transaction is only ever retried after a connection error (on the
+// same endpoint until the failover window elapses, then on the
+// next), never after a plain Horizon rejection (bad sequence number,
+// insufficient fee, and so on) - those are returned to the caller
+// immediately, since a different endpoint won't change the outcome
+// and the caller (e.g. advancePegOut's fee escalation) already knows
+// how to react to them.
+func (p *Pool) SubmitTransaction(txeBase64 string) (horizon.TransactionSuccess, error) {
+	for {
+		client, failoverWindowElapsed := p.activeClient()
+		if failoverWindowElapsed {
+			p.promote()
+			continue
+		}
+
+		succ, err := client.SubmitTransaction(txeBase64)
+		if err == nil {
+			p.clearFailure()
+			return succ, nil
+		}
+		if !isConnectionError(err) {
+			p.clearFailure()
+			return succ, err
+		}
+		p.markFailure()
+		time.Sleep(p.backoff.Next())
+	}
+}
+
+// isConnectionError reports whether err looks like the endpoint
+// itself is unreachable (the failure mode failover exists to work
+// around), as opposed to Horizon reachably rejecting the submitted
+// transaction.
+func isConnectionError(err error) bool {
+	_, ok := errors.Root(err).(*horizon.Error)
+	return !ok
+}
+
+// activeClient returns the currently active client, along with
+// whether it has now been failing for longer than the failover
+// window (in which case the caller should promote the next endpoint
+// before using it).
+func (p *Pool) activeClient() (horizon.ClientInterface, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := !p.failedAt.IsZero() && time.Since(p.failedAt) > p.window
+	return p.clients[p.active], elapsed
+}
+
+// client returns the currently active client under p.mu, for the
+// one-shot methods below that don't retry on failure (a retry would
+// just repeat whatever error Horizon already gave).
+func (p *Pool) client() horizon.ClientInterface {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.clients[p.active]
+}
+
+// LoadAccount, LoadTransaction, SequenceForAccount, and Root are
+// called by the rest of slidechain against an hclient typed as
+// horizon.ClientInterface; Pool's embedded ClientInterface field
+// would promote these automatically, but reading it outside p.mu
+// races with promote()'s write to the same field. These explicit
+// overrides read the active client under the lock instead. Any
+// other ClientInterface method slidechain starts calling later still
+// falls back to the racy promoted version until it gets the same
+// treatment - see the Pool doc for the tradeoff.
+func (p *Pool) LoadAccount(accountID string) (horizon.Account, error) {
+	return p.client().LoadAccount(accountID)
+}
+
+func (p *Pool) LoadTransaction(txHash string) (horizon.Transaction, error) {
+	return p.client().LoadTransaction(txHash)
+}
+
+func (p *Pool) SequenceForAccount(accountID string) (xdr.SequenceNumber, error) {
+	return p.client().SequenceForAccount(accountID)
+}
+
+func (p *Pool) Root() (horizon.Root, error) {
+	return p.client().Root()
+}
+
+func (p *Pool) markFailure() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failedAt.IsZero() {
+		p.failedAt = time.Now()
+	}
+}
+
+func (p *Pool) clearFailure() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failedAt = time.Time{}
+}
+
+// promote advances the active endpoint to the next one in the pool,
+// wrapping around, and resets the failure clock so the newly
+// promoted endpoint gets a fresh FailoverWindow of its own.
+func (p *Pool) promote() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = (p.active + 1) % len(p.clients)
+	p.ClientInterface = p.clients[p.active]
+	p.failedAt = time.Time{}
+}
+
+// ledgerResponse is the subset of Horizon's ledger resource this
+// package needs.
+type ledgerResponse struct {
+	Hash string `json:"hash"`
+}
+
+// LedgerHash fetches the hash of the ledger at seq from the active
+// endpoint. It's used to cross-check that a remembered ledger is
+// still part of the canonical chain before resuming from a stored
+// cursor.
+func (p *Pool) LedgerHash(seq uint64) (string, error) {
+	p.mu.Lock()
+	url := p.urls[p.active]
+	p.mu.Unlock()
+
+	resp, err := http.Get(fmt.Sprintf("%s/ledgers/%d", strings.TrimRight(url, "/"), seq))
+	if err != nil {
+		return "", errors.Wrapf(err, "fetching ledger %d", seq)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Wrapf(errors.New(resp.Status), "fetching ledger %d", seq)
+	}
+	var lr ledgerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+		return "", errors.Wrapf(err, "decoding ledger %d", seq)
+	}
+	return lr.Hash, nil
+}
@@ -0,0 +1,98 @@
+package horizonpool
+
+import (
+	"testing"
+
+	"github.com/stellar/go/clients/horizon"
+)
+
+// fakeClient embeds horizon.ClientInterface so it only needs to
+// implement the methods a given test actually exercises; any other
+// call panics on the nil embedded interface, which is the point.
+type fakeClient struct {
+	horizon.ClientInterface
+	submitTransaction func(txeBase64 string) (horizon.TransactionSuccess, error)
+}
+
+func (f *fakeClient) SubmitTransaction(txeBase64 string) (horizon.TransactionSuccess, error) {
+	return f.submitTransaction(txeBase64)
+}
+
+func TestNewRejectsEmpty(t *testing.T) {
+	_, err := New(nil, Config{})
+	if err == nil {
+		t.Fatal("expected an error constructing a Pool with no endpoints, got nil")
+	}
+}
+
+func TestPromoteWrapsAround(t *testing.T) {
+	p, err := New([]string{"https://a.example", "https://b.example"}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.active != 0 {
+		t.Fatalf("got active index %d, want 0", p.active)
+	}
+	p.promote()
+	if p.active != 1 {
+		t.Fatalf("got active index %d, want 1", p.active)
+	}
+	p.promote()
+	if p.active != 0 {
+		t.Fatalf("got active index %d after wraparound, want 0", p.active)
+	}
+}
+
+func TestSubmitTransactionNoFailoverOnHorizonRejection(t *testing.T) {
+	p, err := New([]string{"https://a.example", "https://b.example"}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	p.clients[0] = &fakeClient{submitTransaction: func(string) (horizon.TransactionSuccess, error) {
+		calls++
+		return horizon.TransactionSuccess{}, &horizon.Error{}
+	}}
+	p.ClientInterface = p.clients[0]
+
+	_, err = p.SubmitTransaction("tx")
+	if err == nil {
+		t.Fatal("expected the Horizon rejection to be returned, got nil")
+	}
+	if calls != 1 {
+		t.Fatalf("got %d calls, want 1 (a Horizon rejection shouldn't fail over or retry)", calls)
+	}
+	if p.active != 0 {
+		t.Fatalf("got active index %d, want 0 (no failover expected)", p.active)
+	}
+}
+
+func TestSubmitTransactionFailsOverOnConnectionError(t *testing.T) {
+	p, err := New([]string{"https://a.example", "https://b.example"}, Config{FailoverWindow: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.window = 0 // fail over on the very first error, for a fast test
+	p.clients[0] = &fakeClient{submitTransaction: func(string) (horizon.TransactionSuccess, error) {
+		return horizon.TransactionSuccess{}, errConnRefused{}
+	}}
+	p.clients[1] = &fakeClient{submitTransaction: func(string) (horizon.TransactionSuccess, error) {
+		return horizon.TransactionSuccess{}, nil
+	}}
+	p.ClientInterface = p.clients[0]
+	p.backoff.Base = 0
+
+	_, err = p.SubmitTransaction("tx")
+	if err != nil {
+		t.Fatalf("got error %s after failing over to a healthy endpoint, want nil", err)
+	}
+	if p.active != 1 {
+		t.Fatalf("got active index %d, want 1 (should have failed over)", p.active)
+	}
+}
+
+// errConnRefused stands in for a transport-level error (not a
+// *horizon.Error), the kind isConnectionError is meant to recognize.
+type errConnRefused struct{}
+
+func (errConnRefused) Error() string { return "connection refused" }
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/bobg/sqlutil"
-	"github.com/chain/txvm/crypto/ed25519"
 	"github.com/chain/txvm/errors"
 	"github.com/chain/txvm/protocol/bc"
 	"github.com/chain/txvm/protocol/txbuilder/standard"
@@ -19,6 +18,7 @@ import (
 
 // buildImportTx builds the import transaction.
 func (c *Custodian) buildImportTx(
+	ctx context.Context,
 	amount int64,
 	assetXDR []byte,
 	recipPubkey []byte,
@@ -51,7 +51,10 @@ func (c *Custodian) buildImportTx(
 	if err != nil {
 		return nil, errors.Wrap(err, "computing transaction ID")
 	}
-	sig := ed25519.Sign(c.privkey, vm.TxID[:])
+	sig, err := c.signer.Sign(ctx, vm.TxID[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "signing import tx")
+	}
 	fmt.Fprintf(buf, "get x'%x' put call\n", sig) // check sig
 	tx2, err := asm.Assemble(buf.String())
 	if err != nil {
@@ -73,18 +76,19 @@ func (c *Custodian) importFromPegs(ctx context.Context) {
 		c.imports.Wait()
 
 		var (
-			txids             []string
-			opNums            []int
-			amounts           []int64
-			assetXDRs, recips [][]byte
+			txids                          []string
+			opNums                         []int
+			amounts                        []int64
+			assetXDRs, recips, nonceHashes [][]byte
 		)
-		const q = `SELECT txid, operation_num, amount, asset_xdr, recipient_pubkey FROM pegs WHERE imported=0`
-		err := sqlutil.ForQueryRows(ctx, c.DB, q, func(txid string, opNum int, amount int64, assetXDR, recip []byte) {
+		const q = `SELECT txid, operation_num, amount, asset_xdr, recipient_pubkey, nonce_hash FROM pegs WHERE imported=0`
+		err := sqlutil.ForQueryRows(ctx, c.DB, q, func(txid string, opNum int, amount int64, assetXDR, recip, nonceHash []byte) {
 			txids = append(txids, txid)
 			opNums = append(opNums, opNum)
 			amounts = append(amounts, amount)
 			assetXDRs = append(assetXDRs, assetXDR)
 			recips = append(recips, recip)
+			nonceHashes = append(nonceHashes, nonceHash)
 		})
 		if err == context.Canceled {
 			return
@@ -94,12 +98,13 @@ func (c *Custodian) importFromPegs(ctx context.Context) {
 		}
 		for i, txid := range txids {
 			var (
-				opNum    = opNums[i]
-				amount   = amounts[i]
-				assetXDR = assetXDRs[i]
-				recip    = recips[i]
+				opNum     = opNums[i]
+				amount    = amounts[i]
+				assetXDR  = assetXDRs[i]
+				recip     = recips[i]
+				nonceHash = nonceHashes[i]
 			)
-			err = c.doImport(ctx, txid, opNum, amount, assetXDR, recip)
+			err = c.doImport(ctx, txid, opNum, amount, assetXDR, recip, nonceHash)
 			if err != nil {
 				if err == context.Canceled {
 					return
@@ -110,10 +115,10 @@ func (c *Custodian) importFromPegs(ctx context.Context) {
 	}
 }
 
-func (c *Custodian) doImport(ctx context.Context, txid string, opNum int, amount int64, assetXDR, recip []byte) error {
+func (c *Custodian) doImport(ctx context.Context, txid string, opNum int, amount int64, assetXDR, recip, nonceHash []byte) error {
 	log.Printf("doing import from tx %s, op %d: %d of asset %x for recipient %x", txid, opNum, amount, assetXDR, recip)
 
-	importTxBytes, err := c.buildImportTx(amount, assetXDR, recip)
+	importTxBytes, err := c.buildImportTx(ctx, amount, assetXDR, recip)
 	if err != nil {
 		return errors.Wrap(err, "building import tx")
 	}
@@ -123,7 +128,7 @@ func (c *Custodian) doImport(ctx context.Context, txid string, opNum int, amount
 		return errors.Wrap(err, "computing transaction ID")
 	}
 	importTx.Runlimit = math.MaxInt64 - runlimit
-	err = c.S.submitTx(ctx, importTx)
+	err = c.S.submitPegTx(ctx, importTx, nonceHash, false)
 	if err != nil {
 		return errors.Wrap(err, "submitting import tx")
 	}
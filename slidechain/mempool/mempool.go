@@ -0,0 +1,196 @@
+// Package mempool implements the transaction pool that sits between
+// a submitted transaction and the BlockBuilder, modeled on the
+// TxPool/TxDesc pattern used by Bytom/Vapor: transactions are
+// validated and deduped once, on arrival, and the BlockBuilder later
+// drains whatever's pending instead of racing submitTx for direct
+// access to the block under construction.
+package mempool
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bobg/multichan"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txvm"
+)
+
+// TxDesc describes one pooled transaction.
+type TxDesc struct {
+	Tx    *bc.Tx
+	Added time.Time
+	Fee   int64
+
+	// PegNonceHash, when set, is the nonce_hash of the Stellar peg-in
+	// that Tx imports. It lets AddTx's RejectFunc veto a duplicate
+	// import for a peg that's already been handled, without the pool
+	// itself knowing anything about pegs or the pegs table.
+	PegNonceHash []byte
+}
+
+// RejectFunc is consulted by AddTx for every TxDesc with a non-nil
+// PegNonceHash. It should report whether the peg identified by
+// nonceHash is in a state that makes pooling another import for it
+// wrong - e.g. already imported, so this is a stale duplicate. A nil
+// RejectFunc accepts every peg tx.
+type RejectFunc func(nonceHash []byte) (reject bool, err error)
+
+// Pool is a set of transactions waiting to be included in a block,
+// indexed by transaction ID so a resubmission is rejected instead of
+// being added to two different blocks.
+type Pool struct {
+	mu          sync.Mutex
+	byID        map[bc.Hash]*TxDesc
+	byNonceHash map[string]bc.Hash
+
+	reject RejectFunc
+
+	// w broadcasts every newly added TxDesc to Subscribe callers, the
+	// same multichan fanout used elsewhere in slidechain to broadcast
+	// committed blocks.
+	w *multichan.W
+}
+
+// New returns an empty Pool.
+func New() *Pool {
+	return &Pool{
+		byID:        make(map[bc.Hash]*TxDesc),
+		byNonceHash: make(map[string]bc.Hash),
+		w:           multichan.New((*TxDesc)(nil)),
+	}
+}
+
+// SetRejectFunc installs the function AddTx consults for txs that
+// carry a PegNonceHash. Callers construct a Pool with New and set
+// this separately, rather than passing it to New, so a Pool remains
+// usable with no peg concept at all (as in the mempool package's own
+// tests) when a caller has no RejectFunc to give it.
+func (p *Pool) SetRejectFunc(f RejectFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reject = f
+}
+
+// AddTx validates tx and adds it to the pool. It returns an error,
+// and leaves the pool unchanged, if tx doesn't validate under its
+// own declared runlimit, if a transaction with the same ID is
+// already pooled, or - for a tx with a PegNonceHash - if an import
+// for that peg is already pooled or the installed RejectFunc vetoes
+// it.
+func (p *Pool) AddTx(td *TxDesc) error {
+	if _, err := txvm.Validate(td.Tx.Program, td.Tx.Version, td.Tx.Runlimit); err != nil {
+		return errors.Wrap(err, "validating tx for mempool")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.byID[td.Tx.ID]; ok {
+		return errors.New("transaction already in mempool")
+	}
+
+	var nonceKey string
+	if td.PegNonceHash != nil {
+		nonceKey = string(td.PegNonceHash)
+		if _, ok := p.byNonceHash[nonceKey]; ok {
+			return errors.New("an import for this peg is already in the mempool")
+		}
+		if p.reject != nil {
+			reject, err := p.reject(td.PegNonceHash)
+			if err != nil {
+				return errors.Wrap(err, "checking peg state for mempool")
+			}
+			if reject {
+				return errors.New("peg is not eligible for import")
+			}
+		}
+	}
+
+	p.byID[td.Tx.ID] = td
+	if nonceKey != "" {
+		p.byNonceHash[nonceKey] = td.Tx.ID
+	}
+	p.w.Write(td)
+	return nil
+}
+
+// RemoveTx removes a transaction from the pool, e.g. once it's been
+// included in a committed block.
+func (p *Pool) RemoveTx(id bc.Hash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeLocked(id)
+}
+
+// removeLocked removes id from both indexes. Callers must hold p.mu.
+func (p *Pool) removeLocked(id bc.Hash) {
+	if td, ok := p.byID[id]; ok && td.PegNonceHash != nil {
+		delete(p.byNonceHash, string(td.PegNonceHash))
+	}
+	delete(p.byID, id)
+}
+
+// GetTx returns the pooled transaction with the given ID, if any.
+func (p *Pool) GetTx(id bc.Hash) (*TxDesc, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	td, ok := p.byID[id]
+	return td, ok
+}
+
+// Pending returns a snapshot of every transaction currently in the
+// pool, oldest first.
+func (p *Pool) Pending() []*TxDesc {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tds := make([]*TxDesc, 0, len(p.byID))
+	for _, td := range p.byID {
+		tds = append(tds, td)
+	}
+	sort.Slice(tds, func(i, j int) bool { return tds[i].Added.Before(tds[j].Added) })
+	return tds
+}
+
+// Drain removes and returns up to maxTxs of the oldest pending
+// transactions whose cumulative Runlimit doesn't exceed
+// maxRunlimit, so a caller building a block can bound both its
+// transaction count and its worst-case execution cost. A maxTxs or
+// maxRunlimit of 0 means unbounded. A lone transaction that by
+// itself exceeds maxRunlimit is still drained, alone, rather than
+// left to stall the mempool forever.
+func (p *Pool) Drain(maxTxs int, maxRunlimit int64) []*TxDesc {
+	pending := p.Pending()
+
+	var (
+		drained      []*TxDesc
+		usedRunlimit int64
+	)
+	for _, td := range pending {
+		if maxTxs > 0 && len(drained) >= maxTxs {
+			break
+		}
+		if maxRunlimit > 0 && usedRunlimit+td.Tx.Runlimit > maxRunlimit {
+			if len(drained) == 0 {
+				drained = append(drained, td)
+			}
+			break
+		}
+		drained = append(drained, td)
+		usedRunlimit += td.Tx.Runlimit
+	}
+
+	p.mu.Lock()
+	for _, td := range drained {
+		p.removeLocked(td.Tx.ID)
+	}
+	p.mu.Unlock()
+
+	return drained
+}
+
+// Subscribe returns a reader that receives every TxDesc added to
+// the pool from this point on.
+func (p *Pool) Subscribe() *multichan.R {
+	return p.w.Reader()
+}
@@ -0,0 +1,97 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+func TestPoolRejectsDuplicate(t *testing.T) {
+	p := New()
+	tx := &bc.Tx{}
+	td := &TxDesc{Tx: tx, Added: time.Now()}
+
+	if err := p.AddTx(td); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddTx(td); err == nil {
+		t.Fatal("expected an error adding a duplicate tx, got nil")
+	}
+}
+
+func TestPoolPendingOldestFirst(t *testing.T) {
+	p := New()
+	now := time.Now()
+
+	newer := &TxDesc{Tx: &bc.Tx{ID: bc.NewHash([32]byte{1})}, Added: now.Add(time.Second)}
+	older := &TxDesc{Tx: &bc.Tx{ID: bc.NewHash([32]byte{2})}, Added: now}
+
+	if err := p.AddTx(newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddTx(older); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := p.Pending()
+	if len(pending) != 2 {
+		t.Fatalf("got %d pending txs, want 2", len(pending))
+	}
+	if pending[0].Tx.ID != older.Tx.ID {
+		t.Fatal("Pending did not return the older tx first")
+	}
+}
+
+func TestPoolRejectsDuplicatePegImport(t *testing.T) {
+	p := New()
+	nonceHash := []byte("peg-nonce")
+
+	first := &TxDesc{Tx: &bc.Tx{ID: bc.NewHash([32]byte{4})}, Added: time.Now(), PegNonceHash: nonceHash}
+	second := &TxDesc{Tx: &bc.Tx{ID: bc.NewHash([32]byte{5})}, Added: time.Now(), PegNonceHash: nonceHash}
+
+	if err := p.AddTx(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddTx(second); err == nil {
+		t.Fatal("expected an error pooling a second import for the same peg, got nil")
+	}
+
+	p.RemoveTx(first.Tx.ID)
+	if err := p.AddTx(second); err != nil {
+		t.Fatalf("pooling an import for the peg after the first cleared: %s", err)
+	}
+}
+
+func TestPoolRejectFunc(t *testing.T) {
+	p := New()
+	p.SetRejectFunc(func(nonceHash []byte) (bool, error) {
+		return string(nonceHash) == "bad", nil
+	})
+
+	good := &TxDesc{Tx: &bc.Tx{ID: bc.NewHash([32]byte{6})}, Added: time.Now(), PegNonceHash: []byte("good")}
+	if err := p.AddTx(good); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := &TxDesc{Tx: &bc.Tx{ID: bc.NewHash([32]byte{7})}, Added: time.Now(), PegNonceHash: []byte("bad")}
+	if err := p.AddTx(bad); err == nil {
+		t.Fatal("expected RejectFunc to veto this tx, got nil error")
+	}
+}
+
+func TestPoolDrainRemoves(t *testing.T) {
+	p := New()
+	td := &TxDesc{Tx: &bc.Tx{ID: bc.NewHash([32]byte{3})}, Added: time.Now()}
+	if err := p.AddTx(td); err != nil {
+		t.Fatal(err)
+	}
+
+	drained := p.Drain(0, 0)
+	if len(drained) != 1 {
+		t.Fatalf("got %d drained txs, want 1", len(drained))
+	}
+	if _, ok := p.GetTx(td.Tx.ID); ok {
+		t.Fatal("drained tx is still in the pool")
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"math"
 	"time"
 
-	"github.com/chain/txvm/crypto/ed25519"
 	i10rjson "github.com/chain/txvm/encoding/json"
 	"github.com/chain/txvm/errors"
 	"github.com/chain/txvm/protocol/bc"
@@ -83,7 +82,10 @@ func (c *Custodian) doPostExport(ctx context.Context, assetXDR, anchor, txid []b
 	if err != nil {
 		return errors.Wrap(err, "computing transaction ID")
 	}
-	sig := ed25519.Sign(c.privkey, vm.TxID[:])
+	sig, err := c.signer.Sign(ctx, vm.TxID[:])
+	if err != nil {
+		return errors.Wrap(err, "signing post-export tx")
+	}
 	b.Op(op.Get).PushdataBytes(sig).Op(op.Put) // con stack: sigchecker; arg stack: sig
 	b.Op(op.Call)
 
@@ -0,0 +1,117 @@
+package slidechain
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/chain/txvm/errors"
+)
+
+// ledgerHistoryDepth bounds how far back watchPegIns can detect and
+// undo a Stellar reorg. Ledgers older than this are assumed final.
+const ledgerHistoryDepth = 20
+
+// ledgerHasher is implemented by an hclient capable of answering
+// "what ledger hash did you last see at sequence N", e.g.
+// *horizonpool.Pool. Plain *horizon.Client and mockhorizon's test
+// double don't implement it, so reconcileLedgerHistory is a no-op
+// against them: there's nothing to fail over from, and tests don't
+// need to exercise the rewind path to pass.
+type ledgerHasher interface {
+	LedgerHash(seq uint64) (string, error)
+}
+
+// recordLedgerHistory remembers that ledger seq had the given hash,
+// trimming anything older than ledgerHistoryDepth entries.
+func (c *Custodian) recordLedgerHistory(ctx context.Context, seq uint64, hash string) error {
+	_, err := c.DB.ExecContext(ctx, `INSERT OR REPLACE INTO ledger_history (ledger_seq, ledger_hash) VALUES ($1, $2)`, seq, hash)
+	if err != nil {
+		return errors.Wrapf(err, "recording ledger history for seq %d", seq)
+	}
+	_, err = c.DB.ExecContext(ctx, `
+		DELETE FROM ledger_history WHERE ledger_seq NOT IN (
+			SELECT ledger_seq FROM ledger_history ORDER BY ledger_seq DESC LIMIT $1
+		)`, ledgerHistoryDepth)
+	return errors.Wrap(err, "trimming ledger history")
+}
+
+// reconcileLedgerHistory is called before watchPegIns resumes from
+// its stored cursor. It walks the remembered (ledger_seq,
+// ledger_hash) pairs from newest to oldest, asking hclient to
+// confirm each one is still part of the canonical chain. The first
+// ledger that still matches is the common ancestor; anything
+// remembered or recorded in pegs after it is undone, since it
+// belongs to a fork hclient no longer has.
+//
+// If none of the remembered ledgers still match - a reorg deeper
+// than ledgerHistoryDepth - this undoes every remembered ledger and
+// peg instead. If hclient doesn't support LedgerHash at all, nothing
+// is checked or undone. Either way a rewind clears the stored cursor
+// entirely (see rewindTo).
+func (c *Custodian) reconcileLedgerHistory(ctx context.Context, hclient interface{}) error {
+	hasher, ok := hclient.(ledgerHasher)
+	if !ok {
+		return nil
+	}
+
+	rows, err := c.DB.QueryContext(ctx, `SELECT ledger_seq, ledger_hash FROM ledger_history ORDER BY ledger_seq DESC`)
+	if err != nil {
+		return errors.Wrap(err, "querying ledger history")
+	}
+	defer rows.Close()
+
+	var (
+		seqs   []uint64
+		hashes []string
+	)
+	for rows.Next() {
+		var (
+			seq  uint64
+			hash string
+		)
+		if err := rows.Scan(&seq, &hash); err != nil {
+			return errors.Wrap(err, "scanning ledger history")
+		}
+		seqs = append(seqs, seq)
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "reading ledger history")
+	}
+
+	for i, seq := range seqs {
+		got, err := hasher.LedgerHash(seq)
+		if err != nil {
+			return errors.Wrapf(err, "fetching ledger hash for seq %d", seq)
+		}
+		if got == hashes[i] {
+			if i == 0 {
+				return nil // newest remembered ledger still matches; nothing to undo
+			}
+			return c.rewindTo(ctx, seq)
+		}
+	}
+
+	// Nothing we remember matches any more: rewind past everything we
+	// have history for and let watchPegIns re-stream from scratch.
+	return c.rewindTo(ctx, 0)
+}
+
+// rewindTo undoes every peg recorded from a ledger after ancestorSeq
+// and clears the stored cursor so watchPegIns re-streams from
+// ancestorSeq forward rather than resuming into a fork.
+func (c *Custodian) rewindTo(ctx context.Context, ancestorSeq uint64) error {
+	_, err := c.DB.ExecContext(ctx, `DELETE FROM pegs WHERE ledger_seq > $1`, ancestorSeq)
+	if err != nil {
+		return errors.Wrapf(err, "undoing pegs after ledger %d", ancestorSeq)
+	}
+	_, err = c.DB.ExecContext(ctx, `DELETE FROM ledger_history WHERE ledger_seq > $1`, ancestorSeq)
+	if err != nil {
+		return errors.Wrapf(err, "trimming ledger history after %d", ancestorSeq)
+	}
+	_, err = c.DB.ExecContext(ctx, `UPDATE custodian SET cursor='' WHERE seed=$1`, c.seed)
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "resetting cursor for rewind")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package slidechain
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/interstellar/slingshot/slidechain/mockhorizon"
+)
+
+// fakeLedgerHasher is a ledgerHasher whose answers are fixed in
+// advance by a test, so reconcileLedgerHistory's rewind logic can be
+// exercised without a real Horizon endpoint.
+type fakeLedgerHasher struct {
+	hashes map[uint64]string
+}
+
+func (f fakeLedgerHasher) LedgerHash(seq uint64) (string, error) {
+	return f.hashes[seq], nil
+}
+
+func TestRecordLedgerHistoryTrims(t *testing.T) {
+	ctx := context.Background()
+	c, cleanup := newTestCustodian(t)
+	defer cleanup()
+
+	for seq := uint64(1); seq <= ledgerHistoryDepth+5; seq++ {
+		if err := c.recordLedgerHistory(ctx, seq, fmt.Sprintf("hash-%d", seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var count int
+	err := c.DB.QueryRow("SELECT COUNT(*) FROM ledger_history").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != ledgerHistoryDepth {
+		t.Fatalf("got %d rows in ledger_history, want %d", count, ledgerHistoryDepth)
+	}
+}
+
+func TestReconcileLedgerHistoryRewindsOnMismatch(t *testing.T) {
+	ctx := context.Background()
+	c, cleanup := newTestCustodian(t)
+	defer cleanup()
+
+	if err := c.recordLedgerHistory(ctx, 1, "good"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.recordLedgerHistory(ctx, 2, "stale"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.DB.ExecContext(ctx, `INSERT INTO pegs (txid, txhash, operation_num, amount, asset_xdr, ledger_seq) VALUES ('t', x'00', 0, 1, x'00', 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := fakeLedgerHasher{hashes: map[uint64]string{1: "good", 2: "not-stale-anymore"}}
+	if err := c.reconcileLedgerHistory(ctx, hasher); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	err = c.DB.QueryRow("SELECT COUNT(*) FROM pegs WHERE ledger_seq > 1").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("got %d pegs rows after the common ancestor, want 0", count)
+	}
+}
+
+// newTestCustodian opens a fresh sqlite db in a temp directory and
+// builds a Custodian over it, for tests that only need db-backed
+// methods and don't talk to Stellar.
+func newTestCustodian(t *testing.T) (*Custodian, func()) {
+	t.Helper()
+	testdir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s/testdb", testdir))
+	if err != nil {
+		os.RemoveAll(testdir)
+		t.Fatal(err)
+	}
+	c, err := newCustodian(context.Background(), db, mockhorizon.New())
+	if err != nil {
+		db.Close()
+		os.RemoveAll(testdir)
+		t.Fatal(err)
+	}
+	return c, func() {
+		db.Close()
+		os.RemoveAll(testdir)
+	}
+}
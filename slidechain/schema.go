@@ -18,7 +18,18 @@ CREATE TABLE IF NOT EXISTS pegs (
   operation_num INTEGER NOT NULL,
   amount INTEGER NOT NULL,
   asset_xdr BLOB NOT NULL,
-  imported INTEGER NOT NULL DEFAULT 0
+  imported INTEGER NOT NULL DEFAULT 0,
+  ledger_seq INTEGER NOT NULL DEFAULT 0
+);
+
+-- ledger_history remembers the last few ledgers watchPegIns has
+-- confirmed pegs against, so that on reconnect (to a failed-over
+-- Horizon endpoint, or after a Stellar reorg) it can cross-check
+-- that the chain it's resuming from still agrees with what it saw
+-- before, and roll back to the common ancestor if not.
+CREATE TABLE IF NOT EXISTS ledger_history (
+  ledger_seq INTEGER NOT NULL PRIMARY KEY,
+  ledger_hash TEXT NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS exports (
@@ -26,7 +37,21 @@ CREATE TABLE IF NOT EXISTS exports (
   recipient TEXT NOT NULL,
   amount INTEGER NOT NULL,
   asset_xdr BLOB NOT NULL,
-  exported INTEGER NOT NULL DEFAULT 0
+  exported INTEGER NOT NULL DEFAULT 0,
+  state TEXT NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  stellar_tx_hash TEXT NOT NULL DEFAULT '',
+  fee INTEGER NOT NULL DEFAULT 100,
+  submitted_at TIMESTAMP,
+  claimable_balance INTEGER NOT NULL DEFAULT 0,
+  balance_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS fee_bumps (
+  txid TEXT NOT NULL,
+  old_fee INTEGER NOT NULL,
+  new_fee INTEGER NOT NULL,
+  bumped_at TIMESTAMP NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS custodian_account (
@@ -0,0 +1,27 @@
+package signer
+
+import (
+	"context"
+
+	"github.com/chain/txvm/crypto/ed25519"
+)
+
+// LocalSigner signs in-process with a key held directly in memory.
+// It's today's behavior (what was previously ed25519.Sign(c.privkey,
+// ...) inline in the custodian) wrapped up to satisfy Signer.
+type LocalSigner struct {
+	key ed25519.PrivateKey
+}
+
+// NewLocalSigner wraps key as a Signer.
+func NewLocalSigner(key ed25519.PrivateKey) LocalSigner {
+	return LocalSigner{key: key}
+}
+
+func (s LocalSigner) Sign(_ context.Context, txid []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, txid), nil
+}
+
+func (s LocalSigner) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
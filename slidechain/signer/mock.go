@@ -0,0 +1,41 @@
+package signer
+
+import (
+	"context"
+
+	"github.com/chain/txvm/crypto/ed25519"
+)
+
+// Mock is a Signer for tests that don't want to stand up a real
+// slidechain-signer process. It signs with an in-memory key like
+// LocalSigner, but lets a test install a Reject hook to exercise the
+// policy-rejection path without a network round trip.
+type Mock struct {
+	key ed25519.PrivateKey
+
+	// Reject, if set, is consulted before signing; returning a
+	// non-nil error simulates a policy rejection.
+	Reject func(txid []byte) error
+}
+
+// NewMock generates a fresh keypair and returns a Mock signer for it.
+func NewMock() (*Mock, error) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Mock{key: key}, nil
+}
+
+func (m *Mock) Sign(_ context.Context, txid []byte) ([]byte, error) {
+	if m.Reject != nil {
+		if err := m.Reject(txid); err != nil {
+			return nil, err
+		}
+	}
+	return ed25519.Sign(m.key, txid), nil
+}
+
+func (m *Mock) PublicKey() ed25519.PublicKey {
+	return m.key.Public().(ed25519.PublicKey)
+}
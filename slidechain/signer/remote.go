@@ -0,0 +1,158 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	i10rnet "github.com/interstellar/starlight/net"
+)
+
+// signRequest and signResponse are the wire format of the
+// slidechain-signer protocol's /sign endpoint.
+type signRequest struct {
+	TxID []byte `json:"txid"`
+}
+
+type signResponse struct {
+	Sig   []byte `json:"sig,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type pubkeyResponse struct {
+	Pubkey ed25519.PublicKey `json:"pubkey"`
+}
+
+// PolicyError is returned by Client.Sign when the remote signer
+// refused to sign a txid: either its policy hook rejected it or its
+// Signer returned an error, which Server reports the same way. It's
+// distinguished from a
+// transport error so callers (and Client's own retry loop) don't
+// keep hammering a signer that has already made up its mind.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("signing policy rejected txid: %s", e.Reason)
+}
+
+// Client is a Signer that delegates to a slidechain-signer process
+// over HTTP, so the signing key itself never has to live on the
+// machine running the custodian's network-facing loops.
+type Client struct {
+	addr       string
+	httpClient *http.Client
+	pubkey     ed25519.PublicKey
+}
+
+// Dial fetches the remote signer's public key, retrying with
+// backoff (the same pattern watchPegIns uses against Horizon) until
+// it succeeds or ctx is canceled.
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	c := &Client{addr: addr, httpClient: http.DefaultClient}
+	backoff := i10rnet.Backoff{Base: 100 * time.Millisecond}
+	for {
+		pubkey, err := c.fetchPublicKey(ctx)
+		if err == nil {
+			c.pubkey = pubkey
+			return c, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff.Next()):
+		}
+	}
+}
+
+func (c *Client) fetchPublicKey(ctx context.Context) (ed25519.PublicKey, error) {
+	req, err := http.NewRequest("GET", c.addr+"/pubkey", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "building pubkey request")
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting pubkey")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading pubkey response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signer returned status %d: %s", resp.StatusCode, body)
+	}
+	var pkResp pubkeyResponse
+	err = json.Unmarshal(body, &pkResp)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshaling pubkey response")
+	}
+	return pkResp.Pubkey, nil
+}
+
+// PublicKey returns the key fetched by Dial. It never contacts the
+// remote signer itself.
+func (c *Client) PublicKey() ed25519.PublicKey {
+	return c.pubkey
+}
+
+// Sign asks the remote signer for a signature over txid, retrying
+// transport errors with backoff until ctx is canceled. A policy
+// rejection is terminal and returned immediately as a *PolicyError.
+func (c *Client) Sign(ctx context.Context, txid []byte) ([]byte, error) {
+	backoff := i10rnet.Backoff{Base: 100 * time.Millisecond}
+	for {
+		sig, err := c.signOnce(ctx, txid)
+		if err == nil {
+			return sig, nil
+		}
+		if _, ok := err.(*PolicyError); ok {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff.Next()):
+		}
+	}
+}
+
+func (c *Client) signOnce(ctx context.Context, txid []byte) ([]byte, error) {
+	reqBody, err := json.Marshal(signRequest{TxID: txid})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling sign request")
+	}
+	req, err := http.NewRequest("POST", c.addr+"/sign", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, errors.Wrap(err, "building sign request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting signature")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading sign response")
+	}
+	var signResp signResponse
+	err = json.Unmarshal(body, &signResp)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling sign response (status %d): %s", resp.StatusCode, err)
+	}
+	if signResp.Error != "" {
+		return nil, &PolicyError{Reason: signResp.Error}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signer returned status %d", resp.StatusCode)
+	}
+	return signResp.Sig, nil
+}
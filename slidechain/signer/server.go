@@ -0,0 +1,73 @@
+package signer
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// PolicyFunc is the signing-policy hook: it's consulted before
+// Server signs a txid, so the process holding the key can refuse to
+// sign something it doesn't recognize or authorize. A nil PolicyFunc
+// allows everything.
+type PolicyFunc func(txid []byte) error
+
+// Server exposes a Signer over HTTP, speaking the protocol that
+// Client dials into. It's the core of the slidechain-signer binary:
+// the binary itself just constructs a LocalSigner around the actual
+// key and calls http.ListenAndServe with a Server as the handler. It
+// sets no PolicyFunc, so it signs every txid it's asked to.
+type Server struct {
+	Signer Signer
+	Policy PolicyFunc
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/pubkey":
+		s.servePubkey(w, r)
+	case "/sign":
+		s.serveSign(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) servePubkey(w http.ResponseWriter, r *http.Request) {
+	err := json.NewEncoder(w).Encode(pubkeyResponse{Pubkey: s.Signer.PublicKey()})
+	if err != nil {
+		log.Printf("signer: encoding pubkey response: %s", err)
+	}
+}
+
+func (s *Server) serveSign(w http.ResponseWriter, r *http.Request) {
+	var req signRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.Policy != nil {
+		if err := s.Policy(req.TxID); err != nil {
+			err = json.NewEncoder(w).Encode(signResponse{Error: err.Error()})
+			if err != nil {
+				log.Printf("signer: encoding policy-rejection response: %s", err)
+			}
+			return
+		}
+	}
+
+	sig, err := s.Signer.Sign(r.Context(), req.TxID)
+	if err != nil {
+		err = json.NewEncoder(w).Encode(signResponse{Error: err.Error()})
+		if err != nil {
+			log.Printf("signer: encoding sign-error response: %s", err)
+		}
+		return
+	}
+	err = json.NewEncoder(w).Encode(signResponse{Sig: sig})
+	if err != nil {
+		log.Printf("signer: encoding sign response: %s", err)
+	}
+}
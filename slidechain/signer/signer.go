@@ -0,0 +1,26 @@
+// Package signer abstracts the custodian's txvm signing key behind
+// a small interface, so the key itself can live in-process
+// (LocalSigner) or in a separate slidechain-signer process that the
+// custodian talks to over HTTP (Client), keeping the hot key off the
+// machine that runs the custodian's network-facing loops.
+package signer
+
+import (
+	"context"
+
+	"github.com/chain/txvm/crypto/ed25519"
+)
+
+// Signer produces signatures over txvm transaction IDs on behalf of
+// the custodian. Implementations may reject a txid - for example, a
+// remote signer enforcing a signing policy - so callers must check
+// the error, not just assume a signature comes back.
+type Signer interface {
+	// Sign returns a signature over txid, or an error if the signer
+	// can't or won't produce one.
+	Sign(ctx context.Context, txid []byte) ([]byte, error)
+
+	// PublicKey returns the public key corresponding to the key this
+	// Signer signs with.
+	PublicKey() ed25519.PublicKey
+}
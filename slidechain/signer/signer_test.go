@@ -0,0 +1,90 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chain/txvm/crypto/ed25519"
+)
+
+func TestLocalSignerRoundTrip(t *testing.T) {
+	pubkey, privkey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewLocalSigner(privkey)
+	if !bytes.Equal(s.PublicKey(), pubkey) {
+		t.Fatal("LocalSigner.PublicKey() does not match the key it was constructed with")
+	}
+	txid := []byte("a txvm transaction id")
+	sig, err := s.Sign(context.Background(), txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(pubkey, txid, sig) {
+		t.Fatal("LocalSigner produced a signature that doesn't verify")
+	}
+}
+
+func TestMockRejects(t *testing.T) {
+	m, err := NewMock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantErr := errors.New("not authorized")
+	m.Reject = func([]byte) error { return wantErr }
+	_, err = m.Sign(context.Background(), []byte("txid"))
+	if err != wantErr {
+		t.Fatalf("got error %v, want %v", err, wantErr)
+	}
+}
+
+func TestClientServerRoundTrip(t *testing.T) {
+	mock, err := NewMock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&Server{Signer: mock})
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := Dial(ctx, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(client.PublicKey(), mock.PublicKey()) {
+		t.Fatal("Client.PublicKey() does not match the server's signer")
+	}
+
+	txid := []byte("a txvm transaction id")
+	sig, err := client.Sign(ctx, txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(mock.PublicKey(), txid, sig) {
+		t.Fatal("Client produced a signature that doesn't verify")
+	}
+}
+
+func TestClientServerPolicyRejection(t *testing.T) {
+	mock, err := NewMock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.Reject = func([]byte) error { return errors.New("unrecognized txid") }
+	srv := httptest.NewServer(&Server{Signer: mock})
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := Dial(ctx, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Sign(ctx, []byte("txid"))
+	if _, ok := err.(*PolicyError); !ok {
+		t.Fatalf("got error %v (%T), want a *PolicyError", err, err)
+	}
+}
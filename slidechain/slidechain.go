@@ -19,6 +19,7 @@ import (
 	"github.com/chain/txvm/protocol/bc"
 	"github.com/chain/txvm/protocol/txvm"
 	"github.com/chain/txvm/protocol/txvm/asm"
+	"github.com/interstellar/slingshot/slidechain/horizonpool"
 	i10rnet "github.com/interstellar/starlight/net"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stellar/go/clients/horizon"
@@ -35,21 +36,26 @@ type custodian struct {
 	accountID xdr.AccountId
 	db        *sql.DB
 	w         *multichan.W
-	hclient   *horizon.Client
+	hclient   horizon.ClientInterface
 	imports   *sync.Cond
 	exports   *sync.Cond
 	network   string
 }
 
-func start(ctx context.Context, addr, dbfile, horizonURL string) (*custodian, error) {
+// start sets up the custodian's db and Horizon client and fetches
+// or creates its Stellar account. horizonURLs must have at least one
+// entry; given more than one, requests go to one active URL via
+// horizonpool, failing over to the next URL when the active one
+// errors continuously - see horizonpool's package doc.
+func start(ctx context.Context, addr, dbfile string, horizonURLs []string) (*custodian, error) {
 	db, err := startdb(dbfile)
 	if err != nil {
 		return nil, errors.Wrap(err, "starting db")
 	}
 
-	hclient := &horizon.Client{
-		URL:  strings.TrimRight(horizonURL, "/"),
-		HTTP: new(http.Client),
+	hclient, err := newHorizonClient(horizonURLs)
+	if err != nil {
+		return nil, errors.Wrap(err, "setting up horizon client")
 	}
 
 	root, err := hclient.Root()
@@ -74,6 +80,22 @@ func start(ctx context.Context, addr, dbfile, horizonURL string) (*custodian, er
 	}, nil
 }
 
+// newHorizonClient returns a plain *horizon.Client for a single URL,
+// or a *horizonpool.Pool that sends requests to one active URL and
+// fails over to the others when more than one is given.
+func newHorizonClient(urls []string) (horizon.ClientInterface, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no horizon URLs given")
+	}
+	if len(urls) == 1 {
+		return &horizon.Client{
+			URL:  strings.TrimRight(urls[0], "/"),
+			HTTP: new(http.Client),
+		}, nil
+	}
+	return horizonpool.New(urls, horizonpool.Config{})
+}
+
 func startdb(dbfile string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", dbfile)
 	if err != nil {
@@ -90,11 +112,17 @@ func main() {
 		addr   = flag.String("addr", "localhost:2423", "server listen address")
 		dbfile = flag.String("db", "slidechain.db", "path to db")
 		url    = flag.String("horizon", "https://horizon-testnet.stellar.org", "horizon server url")
+		urls   = flag.String("horizon-failover", "", "comma-separated additional horizon server urls to fail over to, beyond -horizon")
 	)
 
 	flag.Parse()
 
-	c, err := start(ctx, *addr, *dbfile, *url)
+	horizonURLs := []string{*url}
+	if *urls != "" {
+		horizonURLs = append(horizonURLs, strings.Split(*urls, ",")...)
+	}
+
+	c, err := start(ctx, *addr, *dbfile, horizonURLs)
 	if err != nil {
 		log.Fatal(err)
 	}
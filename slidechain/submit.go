@@ -2,6 +2,9 @@ package slidechain
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -14,23 +17,64 @@ import (
 	"github.com/chain/txvm/protocol"
 	"github.com/chain/txvm/protocol/bc"
 	"github.com/golang/protobuf/proto"
+	"github.com/interstellar/slingshot/slidechain/mempool"
 	"github.com/interstellar/slingshot/slidechain/net"
 )
 
-// TODO: make this configurable.
-var blockInterval = 5 * time.Second
+// SubmitterConfig tunes the latency-vs-throughput tradeoff of block
+// production: how long a block waits for txs, and the size/runlimit
+// budget that can close it early.
+type SubmitterConfig struct {
+	// BlockInterval is how long a block accepts new txs before it's
+	// committed, absent an earlier size/runlimit trigger.
+	BlockInterval time.Duration
+
+	// MaxTxsPerBlock and MaxRunlimitPerBlock bound a block the same
+	// way BlockInterval does, but by size instead of wall time: once
+	// either is hit, the pending block is committed early instead of
+	// waiting out the rest of BlockInterval. Zero means unbounded.
+	MaxTxsPerBlock      int
+	MaxRunlimitPerBlock int64
+
+	// MinBlockInterval is the minimum time after a commit before the
+	// next block is allowed to start, so a burst of arrivals right
+	// after a commit accumulates into one block instead of each
+	// spawning its own single-tx block.
+	MinBlockInterval time.Duration
+}
+
+// DefaultSubmitterConfig holds the default block-cadence settings.
+// newSubmitter does not apply it itself: it uses whatever
+// SubmitterConfig its caller passes, zero fields included.
+var DefaultSubmitterConfig = SubmitterConfig{
+	BlockInterval:       5 * time.Second,
+	MaxTxsPerBlock:      100,
+	MaxRunlimitPerBlock: 0,
+	MinBlockInterval:    500 * time.Millisecond,
+}
 
 type submitter struct {
-	// Protects bb.
+	// Protects bb, timer, and lastCommit.
 	bbmu sync.Mutex
 
 	// Normally nil. Once a tx is submitted, this is set to a new block
 	// builder and a timer set. Other txs that arrive during that
-	// interval are added to the block a-building. When the timer fires,
-	// the block is added to the blockchain and this field is set back to nil.
+	// interval are pooled in mempool. When the timer fires, or the
+	// pool fills past cfg's MaxTxsPerBlock/MaxRunlimitPerBlock, the
+	// pool is drained into the block, the block is added to the
+	// blockchain, and this field is set back to nil.
 	//
 	// This is the only way that blocks are added to the chain.
-	bb *protocol.BlockBuilder
+	bb         *protocol.BlockBuilder
+	timer      *time.Timer
+	lastCommit time.Time
+
+	// pool holds submitted transactions between their arrival at
+	// submitTx and their inclusion in a block built by commitBlock.
+	pool *mempool.Pool
+
+	cfgMu sync.RWMutex
+	cfg   SubmitterConfig
 
 	// New blocks are written here.
 	// Anything monitoring the blockchain can create a reader and consume them.
@@ -42,57 +86,132 @@ type submitter struct {
 	chain *protocol.Chain
 }
 
-func (s *submitter) submitTx(ctx context.Context, tx *bc.Tx, wait bool) error {
-	s.bbmu.Lock()
-	defer s.bbmu.Unlock()
+// newSubmitter builds a submitter backed by db. db may be nil (e.g.
+// in tests that never submit peg imports), in which case pooled txs
+// are never checked against the pegs table.
+func newSubmitter(w *multichan.W, initialBlock *bc.Block, chain *protocol.Chain, db *sql.DB, cfg SubmitterConfig) *submitter {
+	pool := mempool.New()
+	if db != nil {
+		pool.SetRejectFunc(pegRejectFunc(db))
+	}
+	return &submitter{
+		pool:         pool,
+		cfg:          cfg,
+		w:            w,
+		initialBlock: initialBlock,
+		chain:        chain,
+	}
+}
 
-	if s.bb == nil {
-		s.bb = protocol.NewBlockBuilder()
-		nextBlockTime := time.Now().Add(blockInterval)
-
-		st := s.chain.State()
-		if st.Header == nil {
-			err := st.ApplyBlockHeader(s.initialBlock.BlockHeader)
-			if err != nil {
-				return errors.Wrap(err, "initializing empty state")
-			}
+// pegRejectFunc builds a mempool.RejectFunc backed by db. It rejects
+// a pooled import whose peg is already marked imported, since that
+// means an earlier import for the same nonce_hash already completed
+// and this one is a stale duplicate; it has nothing to say about a
+// nonce_hash it doesn't recognize, e.g. a peg db doesn't know about
+// yet, and defers to the pool's own in-flight dedup for that case.
+func pegRejectFunc(db *sql.DB) mempool.RejectFunc {
+	return func(nonceHash []byte) (bool, error) {
+		var imported bool
+		err := db.QueryRow(`SELECT imported FROM pegs WHERE nonce_hash=$1 AND stellar_tx=1`, nonceHash).Scan(&imported)
+		if err == sql.ErrNoRows {
+			return false, nil
 		}
-
-		err := s.bb.Start(s.chain.State(), bc.Millis(nextBlockTime))
 		if err != nil {
-			return errors.Wrap(err, "starting a new tx pool")
+			return false, errors.Wrap(err, "checking peg import state")
 		}
-		log.Printf("starting new block, will commit at %s", nextBlockTime)
-		time.AfterFunc(blockInterval, func() {
-			s.bbmu.Lock()
-			defer s.bbmu.Unlock()
+		return imported, nil
+	}
+}
 
-			defer func() { s.bb = nil }()
+func (s *submitter) config() SubmitterConfig {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.cfg
+}
 
-			unsignedBlock, newSnapshot, err := s.bb.Build()
-			if err != nil {
-				log.Fatalf("building new block: %s", err)
-			}
-			if len(unsignedBlock.Transactions) == 0 {
-				log.Print("skipping commit of empty block")
-				return
-			}
-			b := &bc.Block{UnsignedBlock: unsignedBlock}
-			err = s.chain.CommitAppliedBlock(ctx, b, newSnapshot)
-			if err != nil {
-				log.Fatalf("committing new block: %s", err)
-			}
+// poolFull reports whether the mempool holds enough pending txs or
+// runlimit, per cfg, to trigger an early commit instead of waiting
+// out the rest of BlockInterval.
+func (s *submitter) poolFull(cfg SubmitterConfig) bool {
+	return blockFull(s.pool.Pending(), cfg)
+}
 
-			s.w.Write(b)
-			log.Printf("committed block %d with %d transaction(s)", unsignedBlock.Height, len(unsignedBlock.Transactions))
-		})
+// blockFull is the predicate poolFull applies to a snapshot of the
+// mempool: full once either the tx count or the cumulative runlimit
+// crosses cfg's configured budget, not tx count alone. Split out of
+// poolFull so it can be driven directly in tests without a live pool.
+func blockFull(pending []*mempool.TxDesc, cfg SubmitterConfig) bool {
+	var pendingRunlimit int64
+	for _, td := range pending {
+		pendingRunlimit += td.Tx.Runlimit
 	}
+	return (cfg.MaxTxsPerBlock > 0 && len(pending) >= cfg.MaxTxsPerBlock) ||
+		(cfg.MaxRunlimitPerBlock > 0 && pendingRunlimit >= cfg.MaxRunlimitPerBlock)
+}
+
+// submitTx validates tx and pools it for inclusion in the next
+// block. It returns an error, without pooling tx, if tx is invalid
+// or a transaction with the same ID is already pooled.
+func (s *submitter) submitTx(ctx context.Context, tx *bc.Tx, wait bool) error {
+	return s.submitTxDesc(ctx, &mempool.TxDesc{Tx: tx, Added: time.Now()}, wait)
+}
+
+// submitPegTx behaves like submitTx, but also tags the pooled tx
+// with the peg it imports, so the mempool's RejectFunc can refuse to
+// pool a stale duplicate import for a peg that's already been
+// imported, and so two in-flight imports for the same peg can't be
+// pooled at once.
+func (s *submitter) submitPegTx(ctx context.Context, tx *bc.Tx, nonceHash []byte, wait bool) error {
+	return s.submitTxDesc(ctx, &mempool.TxDesc{Tx: tx, Added: time.Now(), PegNonceHash: nonceHash}, wait)
+}
 
-	err := s.bb.AddTx(bc.NewCommitmentsTx(tx))
+func (s *submitter) submitTxDesc(ctx context.Context, td *mempool.TxDesc, wait bool) error {
+	tx := td.Tx
+	err := s.pool.AddTx(td)
 	if err != nil {
-		return errors.Wrap(err, "adding tx to pool")
+		return errors.Wrap(err, "adding tx to mempool")
+	}
+	log.Printf("added tx %x to the mempool", tx.ID.Bytes())
+
+	cfg := s.config()
+
+	s.bbmu.Lock()
+	if s.bb == nil {
+		wait := cfg.MinBlockInterval - time.Since(s.lastCommit)
+		if wait > 0 {
+			log.Printf("delaying next block by %s to respect MinBlockInterval", wait)
+			time.AfterFunc(wait, func() {
+				s.bbmu.Lock()
+				if s.bb == nil {
+					if err := s.startBlock(ctx); err != nil {
+						log.Printf("starting delayed block: %s", err)
+					}
+				}
+				full := s.poolFull(cfg)
+				s.bbmu.Unlock()
+				// Re-check fullness now that the delayed block has
+				// actually started: the burst that arrived during the
+				// MinBlockInterval throttle may already have filled
+				// the pool, and nothing else re-evaluates this once
+				// submitTxDesc's own caller has returned.
+				if full {
+					log.Printf("pending block full once the delayed block started, committing early")
+					s.commitBlock(ctx)
+				}
+			})
+		} else if err := s.startBlock(ctx); err != nil {
+			s.bbmu.Unlock()
+			return err
+		}
+	}
+	full := s.poolFull(cfg)
+	s.bbmu.Unlock()
+
+	if full {
+		log.Printf("pending block full, committing early")
+		s.commitBlock(ctx)
 	}
-	log.Printf("added tx %x to the pending block", tx.ID.Bytes())
+
 	if wait {
 		log.Printf("waiting on tx %x to hit txvm", tx.ID.Bytes())
 		r := s.w.Reader()
@@ -114,6 +233,78 @@ func (s *submitter) submitTx(ctx context.Context, tx *bc.Tx, wait bool) error {
 	return nil
 }
 
+// startBlock starts a new BlockBuilder and arms the timer that will
+// commit it after blockInterval, unless commitBlock fires early
+// first. Callers must hold bbmu.
+func (s *submitter) startBlock(ctx context.Context) error {
+	blockInterval := s.config().BlockInterval
+	s.bb = protocol.NewBlockBuilder()
+	nextBlockTime := time.Now().Add(blockInterval)
+
+	st := s.chain.State()
+	if st.Header == nil {
+		err := st.ApplyBlockHeader(s.initialBlock.BlockHeader)
+		if err != nil {
+			s.bb = nil
+			return errors.Wrap(err, "initializing empty state")
+		}
+	}
+
+	err := s.bb.Start(s.chain.State(), bc.Millis(nextBlockTime))
+	if err != nil {
+		s.bb = nil
+		return errors.Wrap(err, "starting a new tx pool")
+	}
+	log.Printf("starting new block, will commit at %s", nextBlockTime)
+	s.timer = time.AfterFunc(blockInterval, func() { s.commitBlock(ctx) })
+	return nil
+}
+
+// commitBlock drains the mempool into the pending BlockBuilder,
+// bounded by cfg.MaxTxsPerBlock and cfg.MaxRunlimitPerBlock, and
+// commits the result to the chain. It's a no-op if called after the block it
+// would commit has already been committed by a concurrent caller -
+// e.g. the timer firing just after submitTx committed early.
+func (s *submitter) commitBlock(ctx context.Context) {
+	s.bbmu.Lock()
+	defer s.bbmu.Unlock()
+
+	if s.bb == nil {
+		return
+	}
+	s.timer.Stop()
+	defer func() { s.bb, s.timer = nil, nil }()
+	defer func() { s.lastCommit = time.Now() }()
+
+	cfg := s.config()
+	for _, td := range s.pool.Drain(cfg.MaxTxsPerBlock, cfg.MaxRunlimitPerBlock) {
+		if err := s.bb.AddTx(bc.NewCommitmentsTx(td.Tx)); err != nil {
+			log.Printf("dropping tx %x from block: %s", td.Tx.ID.Bytes(), err)
+		}
+	}
+
+	unsignedBlock, newSnapshot, err := s.bb.Build()
+	if err != nil {
+		log.Fatalf("building new block: %s", err)
+	}
+	if len(unsignedBlock.Transactions) == 0 {
+		log.Print("skipping commit of empty block")
+		return
+	}
+	b := &bc.Block{UnsignedBlock: unsignedBlock}
+	err = s.chain.CommitAppliedBlock(ctx, b, newSnapshot)
+	if err != nil {
+		log.Fatalf("committing new block: %s", err)
+	}
+
+	// The chain's height has now advanced, so any /get request
+	// blocked in BlockWaiter on this (or an earlier) height unblocks
+	// here - whether this block was triggered by the timer or by an
+	// early commit makes no difference to that path.
+	s.w.Write(b)
+	log.Printf("committed block %d with %d transaction(s)", unsignedBlock.Height, len(unsignedBlock.Transactions))
+}
+
 func (s *submitter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 
@@ -213,3 +404,75 @@ func (s *submitter) Get(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 }
+
+// mempoolTx is the JSON shape of one entry in the Mempool handler's
+// response.
+type mempoolTx struct {
+	ID    string `json:"id"`
+	Added string `json:"added"`
+}
+
+// Mempool serves the list of transactions currently pooled and
+// awaiting inclusion in a block, for operator visibility into
+// what's about to go into the chain.
+func (s *submitter) Mempool(w http.ResponseWriter, req *http.Request) {
+	pending := s.pool.Pending()
+	txs := make([]mempoolTx, 0, len(pending))
+	for _, td := range pending {
+		txs = append(txs, mempoolTx{
+			ID:    fmt.Sprintf("%x", td.Tx.ID.Bytes()),
+			Added: td.Added.UTC().Format(time.RFC3339),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(txs); err != nil {
+		net.Errorf(w, http.StatusInternalServerError, "encoding response: %s", err)
+		return
+	}
+}
+
+// Config serves and updates the submitter's SubmitterConfig: GET
+// returns the config currently in effect; POST merges a partial JSON
+// SubmitterConfig into it (an omitted or zero-valued field leaves
+// the existing setting untouched), so block cadence can be tuned
+// without a restart.
+func (s *submitter) Config(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.config()); err != nil {
+			net.Errorf(w, http.StatusInternalServerError, "encoding response: %s", err)
+		}
+		return
+	}
+	if req.Method != http.MethodPost {
+		net.Errorf(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+		return
+	}
+
+	var patch SubmitterConfig
+	if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+		net.Errorf(w, http.StatusBadRequest, "parsing request body: %s", err)
+		return
+	}
+
+	s.cfgMu.Lock()
+	if patch.BlockInterval > 0 {
+		s.cfg.BlockInterval = patch.BlockInterval
+	}
+	if patch.MaxTxsPerBlock > 0 {
+		s.cfg.MaxTxsPerBlock = patch.MaxTxsPerBlock
+	}
+	if patch.MaxRunlimitPerBlock > 0 {
+		s.cfg.MaxRunlimitPerBlock = patch.MaxRunlimitPerBlock
+	}
+	if patch.MinBlockInterval > 0 {
+		s.cfg.MinBlockInterval = patch.MinBlockInterval
+	}
+	updated := s.cfg
+	s.cfgMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		net.Errorf(w, http.StatusInternalServerError, "encoding response: %s", err)
+	}
+}
@@ -0,0 +1,110 @@
+package slidechain
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/interstellar/slingshot/slidechain/mempool"
+)
+
+func TestSubmitterConfigHandler(t *testing.T) {
+	s := &submitter{cfg: DefaultSubmitterConfig}
+
+	get := func() SubmitterConfig {
+		w := httptest.NewRecorder()
+		s.Config(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+		var cfg SubmitterConfig
+		if err := json.Unmarshal(w.Body.Bytes(), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	if got := get(); got != DefaultSubmitterConfig {
+		t.Fatalf("got config %+v, want default %+v", got, DefaultSubmitterConfig)
+	}
+
+	// Only MaxTxsPerBlock is set in the patch; every other,
+	// zero-valued field should leave the existing setting untouched.
+	patch, err := json.Marshal(SubmitterConfig{MaxTxsPerBlock: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.Config(w, httptest.NewRequest(http.MethodPost, "/admin/config", bytes.NewReader(patch)))
+	if w.Code != http.StatusOK && w.Code != 0 {
+		t.Fatalf("got status %d posting a config patch", w.Code)
+	}
+
+	got := get()
+	if got.MaxTxsPerBlock != 7 {
+		t.Fatalf("got MaxTxsPerBlock %d, want 7", got.MaxTxsPerBlock)
+	}
+	if got.BlockInterval != DefaultSubmitterConfig.BlockInterval {
+		t.Fatalf("got BlockInterval %s, want untouched default %s", got.BlockInterval, DefaultSubmitterConfig.BlockInterval)
+	}
+}
+
+func TestPegRejectFunc(t *testing.T) {
+	c, cleanup := newTestCustodian(t)
+	defer cleanup()
+
+	reject := pegRejectFunc(c.DB)
+
+	// No row at all for this nonce_hash: nothing to reject.
+	got, err := reject([]byte("unknown"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got {
+		t.Fatal("got reject=true for an unrecognized nonce_hash, want false")
+	}
+
+	const insert = `INSERT INTO pegs (txid, txhash, operation_num, amount, asset_xdr, nonce_hash, stellar_tx, imported) VALUES ($1, x'00', 0, 1, x'00', $2, $3, $4)`
+	if _, err := c.DB.Exec(insert, "pending", []byte("pending-nonce"), true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DB.Exec(insert, "done", []byte("done-nonce"), true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = reject([]byte("pending-nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got {
+		t.Fatal("got reject=true for a peg that's confirmed but not yet imported, want false")
+	}
+
+	got, err = reject([]byte("done-nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got {
+		t.Fatal("got reject=false for an already-imported peg, want true")
+	}
+}
+
+// TestBlockFullOnRunlimit checks the early-commit predicate
+// submitTxDesc applies to the pool: it's "full" once either the tx
+// count or the cumulative runlimit crosses its configured budget,
+// not tx count alone. It drives blockFull itself, the function
+// poolFull (and so submitTxDesc) actually calls, rather than
+// reimplementing the predicate inline.
+func TestBlockFullOnRunlimit(t *testing.T) {
+	cfg := SubmitterConfig{MaxTxsPerBlock: 1000, MaxRunlimitPerBlock: 100}
+	pending := []*mempool.TxDesc{
+		{Tx: &bc.Tx{Runlimit: 40}},
+		{Tx: &bc.Tx{Runlimit: 70}},
+	}
+	if !blockFull(pending, cfg) {
+		t.Fatal("expected cumulative runlimit over MaxRunlimitPerBlock to report full even though tx count is well under MaxTxsPerBlock")
+	}
+	if blockFull(pending[:1], cfg) {
+		t.Fatal("expected a single 40-runlimit tx under both budgets to report not full")
+	}
+}
@@ -1,7 +1,6 @@
 package slidechain
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -26,6 +25,17 @@ func (c *Custodian) watchPegIns(ctx context.Context) {
 		log.Fatal(err)
 	}
 
+	if err := c.reconcileLedgerHistory(ctx, c.hclient); err != nil {
+		log.Printf("reconciling ledger history: %s, resuming from stored cursor anyway", err)
+	} else if cur != "" {
+		// reconcileLedgerHistory may have rewound the cursor in the db
+		// (e.g. after detecting a reorg); pick that up before streaming.
+		err := c.DB.QueryRow("SELECT cursor FROM custodian").Scan(&cur)
+		if err != nil && err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+	}
+
 	for {
 		err := c.hclient.StreamTransactions(ctx, c.AccountID.Address(), &cur, func(tx horizon.Transaction) {
 			log.Printf("handling Stellar tx %s", tx.ID)
@@ -58,7 +68,7 @@ func (c *Custodian) watchPegIns(ctx context.Context) {
 					log.Fatalf("marshaling asset xdr: %s", err)
 					return
 				}
-				resulted, err := c.DB.ExecContext(ctx, `UPDATE pegs SET amount=$1, asset_xdr=$2, stellar_tx=1 WHERE nonce_hash=$3 AND stellar_tx=0`, payment.Amount, assetXDR, nonceHash)
+				resulted, err := c.DB.ExecContext(ctx, `UPDATE pegs SET amount=$1, asset_xdr=$2, stellar_tx=1, ledger_seq=$3 WHERE nonce_hash=$4 AND stellar_tx=0`, payment.Amount, assetXDR, tx.Ledger, nonceHash)
 				if err != nil {
 					log.Fatalf("updating stellar_tx=1 for hash %x: %s", nonceHash, err)
 				}
@@ -79,6 +89,17 @@ func (c *Custodian) watchPegIns(ctx context.Context) {
 					return
 				}
 
+				// Remember this ledger so a future reconnect can tell
+				// whether it's still part of the canonical chain.
+				if hasher, ok := c.hclient.(ledgerHasher); ok {
+					hash, err := hasher.LedgerHash(uint64(tx.Ledger))
+					if err != nil {
+						log.Printf("fetching hash for ledger %d: %s", tx.Ledger, err)
+					} else if err := c.recordLedgerHistory(ctx, uint64(tx.Ledger), hash); err != nil {
+						log.Printf("recording ledger history: %s", err)
+					}
+				}
+
 				// Wake up a goroutine that executes imports for not-yet-imported pegs.
 				log.Printf("broadcasting import for tx with nonce hash %x", nonceHash)
 				c.imports.Broadcast()
@@ -139,32 +160,38 @@ func (c *Custodian) watchExports(ctx context.Context) {
 					continue
 				}
 
-				// Check this Stellar asset code corresponds to retiredAssetIDBytes.
-				gotAssetID32 := txvm.AssetID(importIssuanceSeed[:], info.AssetXDR)
-				if !bytes.Equal(gotAssetID32[:], retiredAssetIDBytes) {
-					continue
-				}
-
 				var exporter xdr.AccountId
 				err = exporter.SetAddress(info.Exporter)
 				if err != nil {
 					continue
 				}
 
+				// Run the pre-flight checks before admitting this as a
+				// live export: a row that fails them is recorded as
+				// rejected, not silently dropped, so a governance flow
+				// can reissue the retired value instead of losing it.
+				state := exportStatePending
+				if err := c.validateExport(ctx, tx, info, retiredAssetIDBytes, retiredAmount); err != nil {
+					log.Printf("rejecting export: %s", err)
+					state = exportStateRejected
+				}
+
 				// Record the export in the db,
 				// then wake up a goroutine that executes peg-outs on the main chain.
 				const q = `
-					INSERT INTO exports 
-					(txid, exporter, amount, asset_xdr, temp_addr, seqnum)
-					VALUES ($1, $2, $3, $4, $5, $6)`
-				_, err = c.DB.ExecContext(ctx, q, tx.ID.Bytes(), exporter.Address(), retiredAmount, info.AssetXDR, info.TempAddr, info.Seqnum)
+					INSERT INTO exports
+					(txid, exporter, amount, asset_xdr, temp_addr, seqnum, claimable_balance, state)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+				_, err = c.DB.ExecContext(ctx, q, tx.ID.Bytes(), exporter.Address(), retiredAmount, info.AssetXDR, info.TempAddr, info.Seqnum, info.Claimable, state)
 				if err != nil {
 					log.Fatalf("recording export tx: %s", err)
 				}
 
-				log.Printf("recorded export: %d of txvm asset %x (Stellar %x) for %s", retiredAmount, retiredAssetIDBytes, info.AssetXDR, exporter.Address())
+				log.Printf("recorded export: %d of txvm asset %x (Stellar %x) for %s, state %s", retiredAmount, retiredAssetIDBytes, info.AssetXDR, exporter.Address(), state)
 
-				c.exports.Broadcast()
+				if state == exportStatePending {
+					c.exports.Broadcast()
+				}
 
 				i++ // advance past the consumed log ("L") entry
 			}